@@ -0,0 +1,570 @@
+// Command gocachectl operates a gocache cluster through the HTTP and admin
+// APIs of its nodes.
+//
+// Usage:
+//
+//	gocachectl [-addr http://localhost:8001] [-o table|json] [-secret s | -token t] [-ca file [-cert file -key file]] <command> [args]
+//
+// Against a cluster started with -authfile, requests are signed with the
+// cluster's secret, from -secret or $GOCACHE_SECRET, or carry an api
+// token, from -token or $GOCACHE_TOKEN, which only the admin API accepts.
+// Against a cluster served over https, nodes are checked against the CA
+// from -ca, and with mutual TLS -cert and -key are presented to them.
+//
+// Commands:
+//
+//	get <group> <key>               get a value from the node that owns key
+//	mget <group> <key>...           get several values
+//	set [-ttl 1m] <group> <key> <value>
+//	                                store a value on the node that owns key
+//	del <group> <key>               delete a key on every node
+//	scan [-prefix p] [-limit n] <group>
+//	                                list the keys cached by every node
+//	stats [group]                   show group statistics of every node
+//	ring [-v]                       show the peers and, with -v, the ring
+//	owner <key>                     show which node owns key
+//	set-peers <peer>...             push a membership list to the peers
+//	purge <group>                   purge a group on every node
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gocache"
+	pb "gocache/cachepb"
+	"gocache/consistenthash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	basePath  = "/_gocache/"
+	adminPath = "/_admin/"
+)
+
+var (
+	addr   string // node used to discover the cluster
+	output string // "table" or "json"
+	client = &http.Client{Timeout: 10 * time.Second}
+	stdout = io.Writer(os.Stdout) // where results are rendered
+)
+
+// peersInfo mirrors the admin API's reply for /peers.
+type peersInfo struct {
+	Self     string   `json:"self"`
+	Peers    []string `json:"peers"`
+	Replicas int      `json:"replicas"`
+	Owner    string   `json:"owner,omitempty"`
+	Ring     []struct {
+		Hash int    `json:"hash"`
+		Node string `json:"node"`
+	} `json:"ring,omitempty"`
+}
+
+// groupStats mirrors the admin API's reply for /groups/:group.
+type groupStats struct {
+	Node           string `json:"node"`
+	Name           string `json:"name"`
+	Gets           int64  `json:"gets"`
+	CacheHits      int64  `json:"cache_hits"`
+	PeerLoads      int64  `json:"peer_loads"`
+	PeerErrors     int64  `json:"peer_errors"`
+	PeerLoading    int64  `json:"peer_loading"`
+	Loads          int64  `json:"loads"`
+	LoadsDeduped   int64  `json:"loads_deduped"`
+	LocalLoads     int64  `json:"local_loads"`
+	LocalLoadErrs  int64  `json:"local_load_errs"`
+	StaleFills     int64  `json:"stale_fills"`
+	LoadsQueued    int64  `json:"loads_queued"`
+	LoadsShed      int64  `json:"loads_shed"`
+	LoadsInFlight  int64  `json:"loads_in_flight"`
+	ServerShed     int64  `json:"server_shed"`
+	ServerRequests int64  `json:"server_requests"`
+	Items          int    `json:"items"`
+	Bytes          int64  `json:"bytes"`
+	CacheBytes     int64  `json:"cache_bytes"`
+}
+
+// getResult is the outcome of getting one key.
+type getResult struct {
+	Key         string `json:"key"`
+	Node        string `json:"node"`
+	Value       string `json:"value,omitempty"`
+	TTL         int64  `json:"ttl_ms,omitempty"`
+	Version     int64  `json:"version,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// nodeResult is the outcome of an operation on one node.
+type nodeResult struct {
+	Node  string `json:"node"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func main() {
+	flag.StringVar(&addr, "addr", "http://localhost:8001", "address of any gocache node")
+	flag.StringVar(&output, "o", "table", "output format: table or json")
+	secret := flag.String("secret", os.Getenv("GOCACHE_SECRET"), "secret of the cluster to sign requests with")
+	token := flag.String("token", os.Getenv("GOCACHE_TOKEN"), "api token to send to the admin API")
+	caFile := flag.String("ca", "", "CA the nodes' certificates are checked against, rather than the system's")
+	certFile := flag.String("cert", "", "certificate to present to nodes that require mutual TLS")
+	keyFile := flag.String("key", "", "private key of -cert")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gocachectl [-addr url] [-o table|json] [-secret s | -token t] [-ca file [-cert file -key file]] <command> [args]")
+		fmt.Fprintln(os.Stderr, "commands: get, mget, set, del, scan, stats, ring, owner, set-peers, purge")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	addr = strings.TrimSuffix(addr, "/")
+	tlsConfig, err := loadTLSConfig(*certFile, *keyFile, *caFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gocachectl:", err)
+		os.Exit(2)
+	}
+	client.Transport = gocache.NewNodeTransport(tlsConfig)
+	if *secret != "" {
+		client.Transport = gocache.NewAuthTransport(gocache.NewHMACAuth("cluster", []byte(*secret)), client.Transport)
+	} else if *token != "" {
+		client.Transport = gocache.NewAuthTransport(&gocache.BearerAuth{Token: *token}, client.Transport)
+	}
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+
+	switch cmd {
+	case "get":
+		err = cmdGet(args, 2, 2)
+	case "mget":
+		err = cmdGet(args, 2, -1)
+	case "set":
+		err = cmdSet(args)
+	case "del":
+		err = cmdDel(args)
+	case "scan":
+		err = cmdScan(args)
+	case "stats":
+		err = cmdStats(args)
+	case "ring":
+		err = cmdRing(args)
+	case "owner":
+		err = cmdOwner(args)
+	case "set-peers":
+		err = cmdSetPeers(args)
+	case "purge":
+		err = cmdPurge(args)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gocachectl:", err)
+		os.Exit(1)
+	}
+}
+
+// loadTLSConfig returns the TLS configuration to dial nodes with, nil for
+// the defaults if no file is given.
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile != "" {
+		return gocache.LoadTLSConfig(certFile, keyFile, caFile)
+	}
+	if caFile == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return &tls.Config{RootCAs: cas, MinVersion: tls.VersionTLS12}, nil
+}
+
+// nodeURL returns the URL of path on node, which may be a Unix domain
+// socket address.
+func nodeURL(node, path string) string {
+	return gocache.NodeURL(node) + path
+}
+
+// cluster fetches the peers known to addr and builds the same hash ring
+// as the nodes.
+func cluster() (*peersInfo, *consistenthash.Map, error) {
+	var info peersInfo
+	if err := doJSON("GET", nodeURL(addr, adminPath+"peers"), nil, &info); err != nil {
+		return nil, nil, err
+	}
+	if len(info.Peers) == 0 {
+		info.Peers = []string{addr}
+	}
+	ring := consistenthash.New(info.Replicas, nil)
+	ring.Add(info.Peers...)
+	return &info, ring, nil
+}
+
+func cmdGet(args []string, min, max int) error {
+	if len(args) < min || (max > 0 && len(args) > max) {
+		return errors.New("usage: get <group> <key> | mget <group> <key>...")
+	}
+	_, ring, err := cluster()
+	if err != nil {
+		return err
+	}
+
+	group, keys := args[0], args[1:]
+	results := make([]getResult, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			results[i] = getKey(ring.Get(key), group, key)
+		}(i, key)
+	}
+	wg.Wait()
+
+	return render(results, []string{"KEY", "NODE", "VALUE", "TTL(ms)", "VERSION", "ERROR"}, func(w io.Writer) {
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", r.Key, r.Node, r.Value, r.TTL, r.Version, r.Error)
+		}
+	})
+}
+
+// getKey gets a key through the peer protocol of node.
+func getKey(node, group, key string) getResult {
+	result := getResult{Key: key, Node: node}
+	u := nodeURL(node, basePath+url.PathEscape(group)+"/"+url.PathEscape(key))
+	res, err := client.Get(u)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if res.StatusCode != http.StatusOK {
+		result.Error = fmt.Sprintf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+		return result
+	}
+
+	out := &pb.Response{}
+	if err := proto.Unmarshal(body, out); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Value = string(out.GetValue())
+	result.TTL = out.GetTtl()
+	result.Version = out.GetVersion()
+	result.ContentType = out.GetContentType()
+	return result
+}
+
+func cmdSet(args []string) error {
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	ttl := fs.Duration("ttl", 0, "time to live of the value, 0 means forever")
+	contentType := fs.String("type", "", "content type of the value")
+	fs.Parse(args)
+	if fs.NArg() != 3 {
+		return errors.New("usage: set [-ttl 1m] [-type text/plain] <group> <key> <value>")
+	}
+	group, key, value := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+
+	_, ring, err := cluster()
+	if err != nil {
+		return err
+	}
+	node := ring.Get(key)
+	u := nodeURL(node, adminPath+"groups/"+url.PathEscape(group)+"/keys/"+url.PathEscape(key))
+	if *ttl > 0 {
+		u += "?ttl=" + ttl.String()
+	}
+
+	req, err := http.NewRequest("PUT", u, strings.NewReader(value))
+	if err != nil {
+		return err
+	}
+	if *contentType != "" {
+		req.Header.Set("Content-Type", *contentType)
+	}
+	result := nodeResult{Node: node, OK: true}
+	if err := sendJSON(req, nil); err != nil {
+		result.OK, result.Error = false, err.Error()
+	}
+	return renderNodes([]nodeResult{result})
+}
+
+func cmdDel(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: del <group> <key>")
+	}
+	path := "groups/" + url.PathEscape(args[0]) + "/keys/" + url.PathEscape(args[1])
+	return onEveryNode(func(node string) (bool, error) {
+		var out struct {
+			Deleted bool `json:"deleted"`
+		}
+		err := doJSON("DELETE", nodeURL(node, adminPath+path), nil, &out)
+		return out.Deleted, err
+	})
+}
+
+func cmdPurge(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: purge <group>")
+	}
+	path := "groups/" + url.PathEscape(args[0]) + "/purge"
+	return onEveryNode(func(node string) (bool, error) {
+		err := doJSON("POST", nodeURL(node, adminPath+path), nil, nil)
+		return err == nil, err
+	})
+}
+
+func cmdScan(args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "only list keys starting with prefix")
+	limit := fs.Int("limit", 0, "stop after this many keys, 0 lists all")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: scan [-prefix p] [-limit n] <group>")
+	}
+
+	type scanKey struct {
+		Key  string `json:"key"`
+		Node string `json:"node"`
+	}
+	var (
+		keys   []scanKey
+		cursor string
+	)
+	for {
+		var page struct {
+			Keys   []scanKey         `json:"keys"`
+			Next   string            `json:"next"`
+			Errors map[string]string `json:"errors"`
+		}
+		q := url.Values{"cluster": {"1"}, "prefix": {*prefix}, "cursor": {cursor}}
+		u := nodeURL(addr, adminPath+"groups/"+url.PathEscape(fs.Arg(0))+"/scan?"+q.Encode())
+		if err := doJSON("GET", u, nil, &page); err != nil {
+			return err
+		}
+		for node, msg := range page.Errors {
+			fmt.Fprintf(os.Stderr, "gocachectl: %s: %s\n", node, msg)
+		}
+		keys = append(keys, page.Keys...)
+		if page.Next == "" || (*limit > 0 && len(keys) >= *limit) {
+			break
+		}
+		cursor = page.Next
+	}
+	if *limit > 0 && len(keys) > *limit {
+		keys = keys[:*limit]
+	}
+
+	return render(keys, []string{"KEY", "NODE"}, func(w io.Writer) {
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\t%s\n", k.Key, k.Node)
+		}
+	})
+}
+
+func cmdStats(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: stats [group]")
+	}
+	info, _, err := cluster()
+	if err != nil {
+		return err
+	}
+
+	var all []groupStats
+	for _, node := range info.Peers {
+		names := args
+		if len(names) == 0 {
+			if err := doJSON("GET", nodeURL(node, adminPath+"groups"), nil, &names); err != nil {
+				return fmt.Errorf("%s: %v", node, err)
+			}
+		}
+		for _, name := range names {
+			stats := groupStats{Node: node}
+			if err := doJSON("GET", nodeURL(node, adminPath+"groups/"+url.PathEscape(name)), nil, &stats); err != nil {
+				return fmt.Errorf("%s: %v", node, err)
+			}
+			all = append(all, stats)
+		}
+	}
+
+	return render(all, []string{"NODE", "GROUP", "ITEMS", "BYTES", "CACHE_BYTES", "GETS", "HITS", "LOADS", "PEER_LOADS", "LOCAL_LOADS", "ERRORS"}, func(w io.Writer) {
+		for _, s := range all {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", s.Node, s.Name, s.Items, s.Bytes,
+				s.CacheBytes, s.Gets, s.CacheHits, s.Loads, s.PeerLoads, s.LocalLoads, s.PeerErrors+s.LocalLoadErrs)
+		}
+	})
+}
+
+func cmdRing(args []string) error {
+	fs := flag.NewFlagSet("ring", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "show the virtual nodes of the ring")
+	fs.Parse(args)
+
+	var info peersInfo
+	path := nodeURL(addr, adminPath+"peers")
+	if *verbose {
+		path += "?ring=1"
+	}
+	if err := doJSON("GET", path, nil, &info); err != nil {
+		return err
+	}
+
+	if !*verbose {
+		peers := append([]string(nil), info.Peers...)
+		sort.Strings(peers)
+		return render(info, []string{"NODE"}, func(w io.Writer) {
+			for _, peer := range peers {
+				fmt.Fprintln(w, peer)
+			}
+		})
+	}
+	return render(info, []string{"HASH", "NODE"}, func(w io.Writer) {
+		for _, vn := range info.Ring {
+			fmt.Fprintf(w, "%d\t%s\n", vn.Hash, vn.Node)
+		}
+	})
+}
+
+func cmdOwner(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: owner <key>")
+	}
+	var info peersInfo
+	if err := doJSON("GET", nodeURL(addr, adminPath+"peers?key="+url.QueryEscape(args[0])), nil, &info); err != nil {
+		return err
+	}
+	return render(map[string]string{"key": args[0], "owner": info.Owner}, []string{"KEY", "OWNER"}, func(w io.Writer) {
+		fmt.Fprintf(w, "%s\t%s\n", args[0], info.Owner)
+	})
+}
+
+func cmdSetPeers(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: set-peers <peer>...")
+	}
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	results := make([]nodeResult, 0, len(args))
+	for _, peer := range args {
+		result := nodeResult{Node: peer, OK: true}
+		res, err := client.Post(nodeURL(peer, "/set-peers"), "application/json", strings.NewReader(string(body)))
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				err = errors.New(res.Status)
+			}
+		}
+		if err != nil {
+			result.OK, result.Error = false, err.Error()
+		}
+		results = append(results, result)
+	}
+	return renderNodes(results)
+}
+
+// onEveryNode runs fn against every node of the cluster and renders
+// the results.
+func onEveryNode(fn func(node string) (bool, error)) error {
+	info, _, err := cluster()
+	if err != nil {
+		return err
+	}
+	results := make([]nodeResult, 0, len(info.Peers))
+	for _, node := range info.Peers {
+		ok, err := fn(node)
+		result := nodeResult{Node: node, OK: ok}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return renderNodes(results)
+}
+
+func renderNodes(results []nodeResult) error {
+	return render(results, []string{"NODE", "OK", "ERROR"}, func(w io.Writer) {
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%t\t%s\n", r.Node, r.OK, r.Error)
+		}
+	})
+}
+
+// render prints v as JSON, or as a table with header whose rows are
+// written by rows.
+func render(v interface{}, header []string, rows func(w io.Writer)) error {
+	if output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	rows(w)
+	return w.Flush()
+}
+
+// doJSON sends a request with an optional body and decodes the JSON reply
+// into out, if not nil.
+func doJSON(method, u string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	return sendJSON(req, out)
+}
+
+func sendJSON(req *http.Request, out interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s: %s", res.Status, e.Error)
+		}
+		return errors.New(res.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
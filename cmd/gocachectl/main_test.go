@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"gocache"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestUnixSocketNode tests the commands against a node listening on a Unix
+// domain socket.
+func TestUnixSocketNode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g := gocache.NewGroup("ctl", 0, gocache.GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	defer g.Close()
+
+	addr = "unix://" + filepath.Join(t.TempDir(), "node.sock")
+	l, err := gocache.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := gocache.NewHTTPPool(addr)
+	pool.Set(addr)
+	r := gin.New()
+	pool.LoadRouters(r)
+	gocache.NewAdmin(pool).LoadRouters(r)
+	srv := &http.Server{Handler: r}
+	go srv.Serve(l)
+	defer srv.Close()
+	client.Transport = gocache.NewNodeTransport(nil)
+
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	for _, tt := range []struct {
+		run  func() error
+		want string
+	}{
+		{func() error { return cmdGet([]string{"ctl", "Tom"}, 2, 2) }, "value of Tom"},
+		{func() error { return cmdSet([]string{"ctl", "Jack", "589"}) }, "true"},
+		{func() error { return cmdGet([]string{"ctl", "Jack"}, 2, 2) }, "589"},
+		{func() error { return cmdScan([]string{"ctl"}) }, "Jack"},
+		{func() error { return cmdStats([]string{"ctl"}) }, "ctl"},
+		{func() error { return cmdRing(nil) }, addr},
+		{func() error { return cmdOwner([]string{"Tom"}) }, addr},
+		{func() error { return cmdDel([]string{"ctl", "Jack"}) }, "true"},
+		{func() error { return cmdPurge([]string{"ctl"}) }, "true"},
+		{func() error { return cmdSetPeers([]string{addr}) }, "true"},
+	} {
+		out.Reset()
+		if err := tt.run(); err != nil || !strings.Contains(out.String(), tt.want) {
+			t.Errorf("expected %q in the output, got %q, %v", tt.want, out.String(), err)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package gocache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	pb "gocache/cachepb"
+	"gocache/consistenthash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultAdminPath = "/_admin/"
+
+// Admin serves a JSON API to inspect and manage a running node. It is an
+// http.Handler, and can be mounted on the same router as the HTTPPool or
+// served on its own, e.g. to listen on a separate port.
+type Admin struct {
+	pool     *HTTPPool
+	basePath string        // e.g. "/_admin/"
+	auth     Authenticator // see SetAuthenticator
+	authz    Authorizer    // see SetAuthorizer
+	mux      *http.ServeMux
+}
+
+// NewAdmin creates the admin API for the node served by pool.
+func NewAdmin(pool *HTTPPool) *Admin {
+	a := &Admin{
+		pool:     pool,
+		basePath: defaultAdminPath,
+		mux:      http.NewServeMux(),
+	}
+	base := strings.TrimSuffix(a.basePath, "/")
+	a.mux.HandleFunc("GET "+base+"/groups", a.handleListGroups)
+	a.mux.HandleFunc("GET "+base+"/groups/{groupname}", a.handleGroupStats)
+	a.mux.HandleFunc("POST "+base+"/groups/{groupname}/purge", a.handlePurgeGroup)
+	a.mux.HandleFunc("PUT "+base+"/groups/{groupname}/size", a.handleResizeGroup)
+	a.mux.HandleFunc("GET "+base+"/groups/{groupname}/scan", a.handleScan)
+	a.mux.HandleFunc("GET "+base+"/groups/{groupname}/keys/{key}", a.handleGetEntry)
+	a.mux.HandleFunc("PUT "+base+"/groups/{groupname}/keys/{key}", a.handleSetEntry)
+	a.mux.HandleFunc("DELETE "+base+"/groups/{groupname}/keys/{key}", a.handleDeleteEntry)
+	a.mux.HandleFunc("GET "+base+"/peers", a.handlePeers)
+	a.mux.HandleFunc("GET "+base+"/config", a.handleConfig)
+	return a
+}
+
+// SetAuthenticator makes the admin API serve only requests auth accepts.
+// It should be set before the API is served.
+func (a *Admin) SetAuthenticator(auth Authenticator) {
+	a.auth = auth
+}
+
+// SetAuthorizer makes the admin API check the access of the authenticated
+// principal to the group named in the path with authz, e.g. Rules. Read
+// covers stats, scans and entries, Write purges, resizes and changes
+// entries. Group lists and the config only show the groups the principal
+// may read. It should be set before the API is served.
+func (a *Admin) SetAuthorizer(authz Authorizer) {
+	a.authz = authz
+}
+
+// ServeHTTP serves the admin API below its base path, answering 401 to
+// requests the authenticator does not accept.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.auth != nil {
+		principal, err := a.auth.Authenticate(r)
+		if err != nil {
+			writeJSONError(w, http.StatusUnauthorized, ErrUnauthenticated.Error())
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// LoadRouters mounts the admin API on a gin router, see ServeHTTP.
+func (a *Admin) LoadRouters(router *gin.Engine) {
+	router.Any(a.basePath+"*path", gin.WrapH(a))
+}
+
+// entryInfo describes a cached entry.
+type entryInfo struct {
+	Group       string     `json:"group"`
+	Key         string     `json:"key"`
+	Value       []byte     `json:"value"`
+	Len         int        `json:"len"`
+	TTL         int64      `json:"ttl_ms,omitempty"`
+	Expire      *time.Time `json:"expire,omitempty"`
+	Version     int64      `json:"version,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	Cost        int64      `json:"cost,omitempty"`
+	Flags       uint32     `json:"flags,omitempty"`
+}
+
+// scanKey is a key found by a scan and the node caching it.
+type scanKey struct {
+	Key  string `json:"key"`
+	Node string `json:"node"`
+}
+
+// scanResult is a page of a scan.
+type scanResult struct {
+	Keys   []scanKey         `json:"keys"`
+	Next   string            `json:"next"`
+	Errors map[string]string `json:"errors,omitempty"` // keyed by node
+}
+
+// peersInfo describes the node's view of the cluster.
+type peersInfo struct {
+	Self     string                       `json:"self"`
+	Peers    []string                     `json:"peers"`
+	Replicas int                          `json:"replicas"`
+	Owner    string                       `json:"owner,omitempty"`
+	Ring     []consistenthash.VirtualNode `json:"ring,omitempty"`
+}
+
+// groupConfig describes how a group is configured.
+type groupConfig struct {
+	Name        string      `json:"name"`
+	CacheBytes  int64       `json:"cache_bytes"`
+	Compression Compression `json:"compression"`
+	LoadLimits  LoadLimits  `json:"load_limits"`
+}
+
+// nodeConfig describes how the node is configured.
+type nodeConfig struct {
+	Self         string        `json:"self"`
+	BasePath     string        `json:"base_path"`
+	AdminPath    string        `json:"admin_path"`
+	Replicas     int           `json:"replicas"`
+	MemoryBudget int64         `json:"memory_budget"`
+	MemoryUsage  int64         `json:"memory_usage"`
+	Groups       []groupConfig `json:"groups"`
+}
+
+// principalKey is where ServeHTTP keeps the principal in the request's
+// context.
+type principalKey struct{}
+
+// principal returns the principal the request was authenticated as.
+func principal(r *http.Request) string {
+	p, _ := r.Context().Value(principalKey{}).(string)
+	return p
+}
+
+// writeJSON answers v as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// writeJSONError answers an error as {"error": msg}.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// group returns the group named in the path, answering 403 if the
+// principal may not access it and 404 if it does not exist.
+func (a *Admin) group(w http.ResponseWriter, r *http.Request, access Access) *Group {
+	name := r.PathValue("groupname")
+	if a.authz != nil {
+		if err := a.authz.Authorize(principal(r), name, access); err != nil {
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return nil
+		}
+	}
+	g := GetGroup(name)
+	if g == nil {
+		writeJSONError(w, http.StatusNotFound, ErrNoSuchGroup.Error())
+	}
+	return g
+}
+
+func (a *Admin) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.readableGroups(r))
+}
+
+// readableGroups returns the names of the groups the principal may read.
+func (a *Admin) readableGroups(r *http.Request) []string {
+	names := []string{}
+	for _, name := range ListGroups() {
+		if a.authz == nil || a.authz.Authorize(principal(r), name, Read) == nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (a *Admin) handleGroupStats(w http.ResponseWriter, r *http.Request) {
+	if g := a.group(w, r, Read); g != nil {
+		writeJSON(w, http.StatusOK, g.Stats())
+	}
+}
+
+func (a *Admin) handlePurgeGroup(w http.ResponseWriter, r *http.Request) {
+	if g := a.group(w, r, Write); g != nil {
+		g.Purge()
+		writeJSON(w, http.StatusOK, g.Stats())
+	}
+}
+
+func (a *Admin) handleResizeGroup(w http.ResponseWriter, r *http.Request) {
+	g := a.group(w, r, Write)
+	if g == nil {
+		return
+	}
+
+	var body struct {
+		CacheBytes *int64 `json:"cache_bytes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.CacheBytes == nil || *body.CacheBytes < 0 {
+		writeJSONError(w, http.StatusBadRequest, "cache_bytes must be a non-negative integer")
+		return
+	}
+
+	g.SetCacheBytes(*body.CacheBytes)
+	writeJSON(w, http.StatusOK, g.Stats())
+}
+
+func (a *Admin) handleGetEntry(w http.ResponseWriter, r *http.Request) {
+	g := a.group(w, r, Read)
+	if g == nil {
+		return
+	}
+
+	key := r.PathValue("key")
+	view, ok := g.Lookup(key)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "key not cached")
+		return
+	}
+
+	meta := view.Meta()
+	info := entryInfo{
+		Group:       g.name,
+		Key:         key,
+		Value:       view.bytes(),
+		Len:         view.Len(),
+		TTL:         view.TTL().Milliseconds(),
+		Version:     meta.Version,
+		ContentType: meta.ContentType,
+		Cost:        meta.Cost,
+		Flags:       meta.Flags,
+	}
+	if expire := view.Expire(); !expire.IsZero() {
+		info.Expire = &expire
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (a *Admin) handleSetEntry(w http.ResponseWriter, r *http.Request) {
+	g := a.group(w, r, Write)
+	if g == nil {
+		return
+	}
+
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.valueLimit()))
+	if err != nil {
+		status := http.StatusBadRequest
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	meta := Meta{ContentType: strings.TrimSpace(contentType)}
+	q := r.URL.Query()
+	if ttl := q.Get("ttl"); ttl != "" {
+		if meta.TTL, err = time.ParseDuration(ttl); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid ttl: "+err.Error())
+			return
+		}
+	}
+	if version := q.Get("version"); version != "" {
+		if meta.Version, err = strconv.ParseInt(version, 10, 64); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid version: "+err.Error())
+			return
+		}
+	}
+
+	if err := g.Set(r.PathValue("key"), value, meta); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"stored": true})
+}
+
+func (a *Admin) handleDeleteEntry(w http.ResponseWriter, r *http.Request) {
+	if g := a.group(w, r, Write); g != nil {
+		writeJSON(w, http.StatusOK, map[string]bool{"deleted": g.Remove(r.PathValue("key"))})
+	}
+}
+
+// handleScan pages through the keys of a group, of this node only or, with
+// cluster=1, of every node.
+func (a *Admin) handleScan(w http.ResponseWriter, r *http.Request) {
+	g := a.group(w, r, Read)
+	if g == nil {
+		return
+	}
+
+	q := r.URL.Query()
+	prefix, cursor := q.Get("prefix"), q.Get("cursor")
+	limit := 100
+	var err error
+	if q.Has("limit") {
+		limit, err = strconv.Atoi(q.Get("limit"))
+	}
+	if err == nil {
+		limit, err = scanLimit(limit)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, errScanLimit.Error())
+		return
+	}
+
+	keys, next := g.Scan(prefix, cursor, limit)
+	result := scanResult{Keys: make([]scanKey, 0, len(keys)), Next: next}
+	for _, key := range keys {
+		result.Keys = append(result.Keys, scanKey{Key: key, Node: a.pool.Self()})
+	}
+	if q.Get("cluster") != "" {
+		result = a.scanCluster(g.name, prefix, cursor, limit, result)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// scanCluster asks every peer for the same page as local and merges their
+// pages into one. Every peer returns its smallest keys after cursor, so
+// the smallest limit keys of the merge are the cluster's next page.
+func (a *Admin) scanCluster(group, prefix, cursor string, limit int, local scanResult) scanResult {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		all  = local.Keys
+		more = local.Next != ""
+		errs = map[string]string{}
+	)
+	req := &pb.ScanRequest{Group: group, Prefix: prefix, Cursor: cursor, Limit: int32(limit)}
+	for node, scanner := range a.pool.scanners() {
+		wg.Add(1)
+		go func(node string, scanner PeerScanner) {
+			defer wg.Done()
+			res := &pb.ScanResponse{}
+			err := scanner.Scan(req, res)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[node] = err.Error()
+				return
+			}
+			for _, key := range res.GetKeys() {
+				all = append(all, scanKey{Key: key, Node: node})
+			}
+			more = more || res.GetNext() != ""
+		}(node, scanner)
+	}
+	wg.Wait()
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Key != all[j].Key {
+			return all[i].Key < all[j].Key
+		}
+		return all[i].Node < all[j].Node
+	})
+	if len(all) > limit {
+		// keep every node's copy of the last key, the cursor moves past it
+		n := limit
+		for n < len(all) && all[n].Key == all[n-1].Key {
+			n++
+		}
+		all, more = all[:n], more || n < len(all)
+	}
+	result := scanResult{Keys: all}
+	if more && len(all) > 0 {
+		result.Next = all[len(all)-1].Key
+	}
+	if len(errs) > 0 {
+		result.Errors = errs
+	}
+	return result
+}
+
+func (a *Admin) handlePeers(w http.ResponseWriter, r *http.Request) {
+	info := peersInfo{
+		Self:     a.pool.Self(),
+		Peers:    a.pool.Peers(),
+		Replicas: defaultReplicas,
+	}
+	q := r.URL.Query()
+	if key := q.Get("key"); key != "" {
+		info.Owner = a.pool.Owner(key)
+	}
+	if q.Get("ring") != "" {
+		info.Ring = a.pool.Ring()
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (a *Admin) handleConfig(w http.ResponseWriter, r *http.Request) {
+	config := nodeConfig{
+		Self:         a.pool.Self(),
+		BasePath:     a.pool.basePath,
+		AdminPath:    a.basePath,
+		Replicas:     defaultReplicas,
+		MemoryBudget: MemoryBudget(),
+		MemoryUsage:  MemoryUsage(),
+		Groups:       []groupConfig{},
+	}
+	for _, name := range a.readableGroups(r) {
+		if g := GetGroup(name); g != nil {
+			_, cacheBytes := g.mainCache.stats()
+			config.Groups = append(config.Groups, groupConfig{
+				Name:        name,
+				CacheBytes:  cacheBytes,
+				Compression: g.Compression(),
+				LoadLimits:  g.LoadLimits(),
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, config)
+}
@@ -0,0 +1,150 @@
+package gocache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminRequest sends a request to the admin API and decodes its JSON reply.
+func adminRequest(t *testing.T, r http.Handler, method, path, body string, out interface{}) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decoding %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+// TestAdmin tests the admin API end to end.
+func TestAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g := NewGroup("admin", 2<<10, MetaGetterFunc(
+		func(key string) ([]byte, Meta, error) {
+			return []byte(db[key]), Meta{Version: 3, ContentType: "text/plain"}, nil
+		}))
+	defer g.Close()
+	g.Get("Tom")
+
+	pool := NewHTTPPool("http://localhost:8001")
+	pool.Set("http://localhost:8001", "http://localhost:8002")
+	r := gin.New()
+	NewAdmin(pool).LoadRouters(r)
+
+	var names []string
+	if code := adminRequest(t, r, "GET", "/_admin/groups", "", &names); code != http.StatusOK || !strings.Contains(strings.Join(names, ","), "admin") {
+		t.Fatalf("list groups: %d %v", code, names)
+	}
+
+	var stats GroupStats
+	if adminRequest(t, r, "GET", "/_admin/groups/admin", "", &stats); stats.Items != 1 || stats.LocalLoads != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	var entry entryInfo
+	if code := adminRequest(t, r, "GET", "/_admin/groups/admin/keys/Tom", "", &entry); code != http.StatusOK ||
+		string(entry.Value) != "630" || entry.Version != 3 || entry.ContentType != "text/plain" {
+		t.Fatalf("get entry: %d %+v", code, entry)
+	}
+
+	var deleted map[string]bool
+	if adminRequest(t, r, "DELETE", "/_admin/groups/admin/keys/Tom", "", &deleted); !deleted["deleted"] {
+		t.Fatalf("delete entry failed")
+	}
+	if code := adminRequest(t, r, "GET", "/_admin/groups/admin/keys/Tom", "", nil); code != http.StatusNotFound {
+		t.Fatalf("deleted entry still found: %d", code)
+	}
+
+	if code := adminRequest(t, r, "PUT", "/_admin/groups/admin/size", `{"cache_bytes": 4096}`, &stats); code != http.StatusOK || stats.CacheBytes != 4096 {
+		t.Fatalf("resize: %d %+v", code, stats)
+	}
+	if code := adminRequest(t, r, "PUT", "/_admin/groups/admin/size", `{}`, nil); code != http.StatusBadRequest {
+		t.Fatalf("resize without size should fail, got %d", code)
+	}
+
+	var stored map[string]bool
+	if adminRequest(t, r, "PUT", "/_admin/groups/admin/keys/Lee?ttl=1m&version=9", "563", &stored); !stored["stored"] {
+		t.Fatalf("set entry failed")
+	}
+	if adminRequest(t, r, "GET", "/_admin/groups/admin/keys/Lee", "", &entry); string(entry.Value) != "563" ||
+		entry.Version != 9 || entry.TTL <= 0 {
+		t.Fatalf("set entry: %+v", entry)
+	}
+	if code := adminRequest(t, r, "PUT", "/_admin/groups/admin/keys/Big", strings.Repeat("x", 4097), nil); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized entry should be refused, got %d", code)
+	}
+
+	g.Get("Jack")
+	if adminRequest(t, r, "POST", "/_admin/groups/admin/purge", "", &stats); stats.Items != 0 {
+		t.Fatalf("purge left %d items", stats.Items)
+	}
+
+	var peers peersInfo
+	if adminRequest(t, r, "GET", "/_admin/peers?ring=1&key=Tom", "", &peers); len(peers.Peers) != 2 ||
+		len(peers.Ring) != 2*defaultReplicas || peers.Owner == "" {
+		t.Fatalf("unexpected peers %+v", peers)
+	}
+
+	var config nodeConfig
+	if adminRequest(t, r, "GET", "/_admin/config", "", &config); config.Self != "http://localhost:8001" || len(config.Groups) == 0 {
+		t.Fatalf("unexpected config %+v", config)
+	}
+
+	if code := adminRequest(t, r, "GET", "/_admin/groups/unknown", "", nil); code != http.StatusNotFound {
+		t.Fatalf("unknown group should be 404, got %d", code)
+	}
+}
+
+// TestAdminScan tests local and cluster-wide scans. Both nodes run in this
+// process and share the group, so every key is found on both.
+func TestAdminScan(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g := NewGroup("admin-scan", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer g.Close()
+	for _, key := range []string{"user:1", "user:2", "user:3", "item:1"} {
+		g.Set(key, []byte(key), Meta{})
+	}
+
+	peer := gin.New()
+	NewHTTPPool("").LoadRouters(peer)
+	srv := httptest.NewServer(peer)
+	defer srv.Close()
+
+	pool := NewHTTPPool("http://self")
+	pool.Set("http://self", srv.URL)
+	// the admin API is a handler of its own, gin is not needed
+	r := NewAdmin(pool)
+
+	var res scanResult
+	adminRequest(t, r, "GET", "/_admin/groups/admin-scan/scan?prefix=user:&limit=2", "", &res)
+	if len(res.Keys) != 2 || res.Keys[0].Key != "user:1" || res.Next != "user:2" {
+		t.Fatalf("unexpected local page %+v", res)
+	}
+
+	var keys []scanKey
+	cursor := ""
+	for {
+		res = scanResult{}
+		adminRequest(t, r, "GET", "/_admin/groups/admin-scan/scan?cluster=1&prefix=user:&limit=3&cursor="+cursor, "", &res)
+		if len(res.Errors) > 0 {
+			t.Fatalf("scan errors: %v", res.Errors)
+		}
+		keys = append(keys, res.Keys...)
+		if res.Next == "" {
+			break
+		}
+		cursor = res.Next
+	}
+	if len(keys) != 6 || keys[0] != (scanKey{"user:1", srv.URL}) || keys[1] != (scanKey{"user:1", "http://self"}) {
+		t.Fatalf("unexpected cluster scan %+v", keys)
+	}
+}
@@ -0,0 +1,372 @@
+package gocache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxSkew is how far the clocks of an HMACAuth's signer and
+// verifier may drift apart by default.
+const defaultMaxSkew = 5 * time.Minute
+
+// maxSignedBody caps the body an HMACAuth reads to check a signature.
+const maxSignedBody = maxValueBytes
+
+// Headers of requests signed by an HMACAuth.
+const (
+	hmacKeyHeader       = "X-Gocache-Key-Id"
+	hmacTimestampHeader = "X-Gocache-Timestamp"
+	hmacSignatureHeader = "X-Gocache-Signature"
+)
+
+var (
+	// ErrUnauthenticated is returned by an Authenticator for a request
+	// without valid credentials.
+	ErrUnauthenticated = errors.New("gocache: unauthenticated")
+	// ErrForbidden is returned by an Authorizer for an access it denies.
+	ErrForbidden = errors.New("gocache: forbidden")
+)
+
+// An Authenticator adds credentials to the requests a node sends and
+// checks those of the requests it serves. HTTPPool authenticates the
+// peer and membership routes with one, and Admin its routes.
+type Authenticator interface {
+	// Sign adds credentials to r.
+	Sign(r *http.Request) error
+	// Authenticate returns who sent r, or ErrUnauthenticated if r does
+	// not come with valid credentials.
+	Authenticate(r *http.Request) (principal string, err error)
+}
+
+// HMACAuth authenticates requests signed with a secret shared by the
+// nodes of a cluster. The signature covers the method, the path and
+// query, the body and the time of the request, so a signed request cannot
+// be altered, and cannot be replayed once MaxSkew has passed.
+//
+// Within MaxSkew a signed request can be replayed as is: signatures are
+// not remembered, as a peer legitimately sends the same request, e.g. a
+// get of the same key, several times a second. Whoever sees a signed
+// request, say a membership update, can send it again until it expires,
+// so serve the nodes over TLS where the network is not trusted and keep
+// MaxSkew as short as the clocks allow.
+type HMACAuth struct {
+	KeyID   string            // names the key requests are signed with
+	Keys    map[string][]byte // keys accepted, by id; the id is the principal
+	MaxSkew time.Duration     // 5m if zero
+}
+
+// NewHMACAuth returns an HMACAuth that signs with and accepts only the
+// secret named keyID.
+func NewHMACAuth(keyID string, secret []byte) *HMACAuth {
+	return &HMACAuth{KeyID: keyID, Keys: map[string][]byte{keyID: secret}}
+}
+
+// Sign implements Authenticator.
+func (a *HMACAuth) Sign(r *http.Request) error {
+	key, ok := a.Keys[a.KeyID]
+	if !ok {
+		return fmt.Errorf("gocache: no hmac key %q", a.KeyID)
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	r.Header.Set(hmacKeyHeader, a.KeyID)
+	r.Header.Set(hmacTimestampHeader, ts)
+	r.Header.Set(hmacSignatureHeader, hex.EncodeToString(hmacSignature(key, r, ts, body)))
+	return nil
+}
+
+// Authenticate implements Authenticator.
+func (a *HMACAuth) Authenticate(r *http.Request) (string, error) {
+	id := r.Header.Get(hmacKeyHeader)
+	key, ok := a.Keys[id]
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	ts := r.Header.Get(hmacTimestampHeader)
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", ErrUnauthenticated
+	}
+	skew := a.MaxSkew
+	if skew <= 0 {
+		skew = defaultMaxSkew
+	}
+	if d := time.Since(time.Unix(secs, 0)); d > skew || d < -skew {
+		return "", ErrUnauthenticated
+	}
+	sig, err := hex.DecodeString(r.Header.Get(hmacSignatureHeader))
+	if err != nil {
+		return "", ErrUnauthenticated
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(nil, r.Body, maxSignedBody)
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal(sig, hmacSignature(key, r, ts, body)) {
+		return "", ErrUnauthenticated
+	}
+	return id, nil
+}
+
+// hmacSignature signs the parts of r that an HMACAuth protects.
+func hmacSignature(key []byte, r *http.Request, ts string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%x", r.Method, r.URL.RequestURI(), ts, sum)
+	return mac.Sum(nil)
+}
+
+// readBody returns the body of r and leaves r with an unread copy of it.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	rc := r.Body
+	if r.GetBody != nil {
+		// leave the body to whoever shares it, e.g. the request a
+		// RoundTripper cloned r from
+		var err error
+		if rc, err = r.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	body, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return body, nil
+}
+
+// BearerAuth authenticates requests by the bearer token in their
+// Authorization header. Unlike an HMACAuth it sends a token that works
+// for anyone who sees it, so it is meant for clients such as gocachectl,
+// over TLS.
+type BearerAuth struct {
+	Token  string            // sent with requests
+	Tokens map[string]string // principals by the tokens accepted
+}
+
+// Sign implements Authenticator.
+func (a *BearerAuth) Sign(r *http.Request) error {
+	if a.Token == "" {
+		return errors.New("gocache: no bearer token")
+	}
+	r.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// Authenticate implements Authenticator.
+func (a *BearerAuth) Authenticate(r *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", ErrUnauthenticated
+	}
+	// compare with every token, taking as long whichever one matches
+	principal, found := "", false
+	for t, p := range a.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			principal, found = p, true
+		}
+	}
+	if !found {
+		return "", ErrUnauthenticated
+	}
+	return principal, nil
+}
+
+// MultiAuth returns an Authenticator that signs with the first of auths
+// and accepts requests any of them accepts, e.g. HMAC signatures from
+// peers and bearer tokens from operators.
+func MultiAuth(auths ...Authenticator) Authenticator {
+	return multiAuth(auths)
+}
+
+type multiAuth []Authenticator
+
+func (m multiAuth) Sign(r *http.Request) error {
+	if len(m) == 0 {
+		return errors.New("gocache: no authenticators")
+	}
+	return m[0].Sign(r)
+}
+
+func (m multiAuth) Authenticate(r *http.Request) (string, error) {
+	for _, a := range m {
+		if principal, err := a.Authenticate(r); err == nil {
+			return principal, nil
+		} else if !errors.Is(err, ErrUnauthenticated) {
+			return "", err
+		}
+	}
+	return "", ErrUnauthenticated
+}
+
+// NewAuthTransport returns a RoundTripper that signs each request with
+// auth before sending it with base, http.DefaultTransport if nil.
+func NewAuthTransport(auth Authenticator, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &authTransport{auth: auth, base: base}
+}
+
+type authTransport struct {
+	auth Authenticator
+	base http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request it is given
+	r = r.Clone(r.Context())
+	if err := t.auth.Sign(r); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(r)
+}
+
+// SetAuthenticator makes the pool sign the requests it sends to peers
+// with auth, and serve gets, scans and membership updates only to
+// requests auth accepts. The health check on "/" stays open. nil, the
+// default, accepts anyone.
+func (p *HTTPPool) SetAuthenticator(auth Authenticator) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.auth = auth
+	for peer, getter := range p.httpGetters {
+		if getter.client != nil {
+			getter.client.CloseIdleConnections()
+		}
+		p.httpGetters[peer] = newHTTPGetter(peer, p.basePath, p.tlsConfig, auth)
+	}
+}
+
+func (p *HTTPPool) authenticator() Authenticator {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.auth
+}
+
+// authenticate answers 401 and returns false if auth, which may be nil,
+// does not accept r.
+func authenticate(w http.ResponseWriter, r *http.Request, auth Authenticator) (principal string, ok bool) {
+	if auth == nil {
+		return "", true
+	}
+	principal, err := auth.Authenticate(r)
+	if err != nil {
+		writeText(w, http.StatusUnauthorized, ErrUnauthenticated.Error())
+		return "", false
+	}
+	return principal, true
+}
+
+// Access is what an Authorizer grants on a group.
+type Access uint8
+
+const (
+	Read  Access = 1 << iota // get, scan and inspect entries
+	Write                    // set, delete and purge entries, resize
+)
+
+func (a Access) String() string {
+	var s string
+	if a&Read != 0 {
+		s += "r"
+	}
+	if a&Write != 0 {
+		s += "w"
+	}
+	return s
+}
+
+// MarshalText encodes a as "r", "w" or "rw".
+func (a Access) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText decodes "r", "w" or "rw".
+func (a *Access) UnmarshalText(b []byte) error {
+	var access Access
+	for _, c := range string(b) {
+		switch c {
+		case 'r':
+			access |= Read
+		case 'w':
+			access |= Write
+		default:
+			return fmt.Errorf("gocache: invalid access %q", b)
+		}
+	}
+	*a = access
+	return nil
+}
+
+// An Authorizer decides what principals may do with groups.
+type Authorizer interface {
+	// Authorize returns ErrForbidden if principal may not access group.
+	// The principal is empty for requests that were not authenticated.
+	Authorize(principal, group string, access Access) error
+}
+
+// AuthorizerFunc implements Authorizer with a function.
+type AuthorizerFunc func(principal, group string, access Access) error
+
+// Authorize implements Authorizer interface.
+func (f AuthorizerFunc) Authorize(principal, group string, access Access) error {
+	return f(principal, group, access)
+}
+
+// Rule grants a principal access to a group. "*" matches any principal,
+// or any group.
+type Rule struct {
+	Principal string `json:"principal"`
+	Group     string `json:"group"`
+	Access    Access `json:"access"`
+}
+
+// Rules is an Authorizer that grants what any of its rules grants, and
+// denies anything else.
+type Rules []Rule
+
+// Authorize implements Authorizer.
+func (rs Rules) Authorize(principal, group string, access Access) error {
+	var granted Access
+	for _, r := range rs {
+		if (r.Principal == "*" || r.Principal == principal) && (r.Group == "*" || r.Group == group) {
+			granted |= r.Access
+		}
+	}
+	if access&^granted != 0 {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// check that the authenticators and authorizers implement their interfaces
+var (
+	_ Authenticator = (*HMACAuth)(nil)
+	_ Authenticator = (*BearerAuth)(nil)
+	_ Authenticator = multiAuth(nil)
+	_ Authorizer    = Rules(nil)
+)
@@ -0,0 +1,275 @@
+package gocache
+
+import (
+	"encoding/hex"
+	"errors"
+	pb "gocache/cachepb"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHMACAuth(t *testing.T) {
+	auth := NewHMACAuth("cluster", []byte("secret"))
+	signed := func(method, target, body string) *http.Request {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if err := auth.Sign(r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := signed("POST", "/set-peers", `["http://a"]`)
+	if principal, err := auth.Authenticate(r); err != nil || principal != "cluster" {
+		t.Fatalf("expected the signed request to be accepted, got %q, %v", principal, err)
+	}
+	if b, _ := io.ReadAll(r.Body); string(b) != `["http://a"]` {
+		t.Fatalf("expected the body to be left to the handler, got %q", b)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(r *http.Request)
+	}{
+		{"path", func(r *http.Request) { r.URL.Path = "/_gocache/g/k2" }},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "fallback=1" }},
+		{"method", func(r *http.Request) { r.Method = "POST" }},
+		{"body", func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader("x")); r.GetBody = nil }},
+		{"key", func(r *http.Request) { r.Header.Set(hmacKeyHeader, "other") }},
+		{"unsigned", func(r *http.Request) { r.Header.Del(hmacSignatureHeader) }},
+		{"expired", func(r *http.Request) {
+			// re-signed an hour ago
+			ts := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+			r.Header.Set(hmacTimestampHeader, ts)
+			sig := hmacSignature([]byte("secret"), r, ts, nil)
+			r.Header.Set(hmacSignatureHeader, hex.EncodeToString(sig))
+		}},
+	}
+	for _, tt := range tests {
+		r := signed("GET", "/_gocache/g/k1", "")
+		tt.tamper(r)
+		if _, err := auth.Authenticate(r); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: expected ErrUnauthenticated, got %v", tt.name, err)
+		}
+	}
+
+	other := NewHMACAuth("cluster", []byte("other secret"))
+	if _, err := other.Authenticate(signed("GET", "/_gocache/g/k1", "")); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected another secret to be refused, got %v", err)
+	}
+
+	// the body is not read past maxSignedBody to check the signature
+	r = signed("POST", "/set-peers", "")
+	r.Body, r.GetBody = io.NopCloser(io.LimitReader(zeros{}, maxSignedBody+1)), nil
+	var tooLarge *http.MaxBytesError
+	if _, err := auth.Authenticate(r); !errors.As(err, &tooLarge) {
+		t.Fatalf("expected the oversized body to be refused, got %v", err)
+	}
+}
+
+// zeros reads zero bytes forever.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestBearerAuth(t *testing.T) {
+	bearer := &BearerAuth{Token: "t1", Tokens: map[string]string{"t1": "alice", "t2": "bob"}}
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := bearer.Authenticate(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected a request without a token to be refused, got %v", err)
+	}
+	bearer.Sign(r)
+	if principal, err := bearer.Authenticate(r); err != nil || principal != "alice" {
+		t.Fatalf("expected alice, got %q, %v", principal, err)
+	}
+	r.Header.Set("Authorization", "Bearer t3")
+	if _, err := bearer.Authenticate(r); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected an unknown token to be refused, got %v", err)
+	}
+
+	// peers sign with the first, operators may use either
+	hmacAuth := NewHMACAuth("cluster", []byte("secret"))
+	multi := MultiAuth(hmacAuth, bearer)
+	r = httptest.NewRequest("GET", "/", nil)
+	multi.Sign(r)
+	if r.Header.Get(hmacSignatureHeader) == "" || r.Header.Get("Authorization") != "" {
+		t.Fatal("expected the request to be signed by the first authenticator")
+	}
+	if principal, err := multi.Authenticate(r); err != nil || principal != "cluster" {
+		t.Fatalf("expected cluster, got %q, %v", principal, err)
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer t2")
+	if principal, err := multi.Authenticate(r); err != nil || principal != "bob" {
+		t.Fatalf("expected bob, got %q, %v", principal, err)
+	}
+}
+
+func TestRules(t *testing.T) {
+	rules := Rules{
+		{Principal: "alice", Group: "scores", Access: Read | Write},
+		{Principal: "*", Group: "public", Access: Read},
+		{Principal: "ops", Group: "*", Access: Read},
+		{Principal: "ops", Group: "*", Access: Write},
+	}
+	tests := []struct {
+		principal, group string
+		access           Access
+		allowed          bool
+	}{
+		{"alice", "scores", Read, true},
+		{"alice", "scores", Read | Write, true},
+		{"alice", "public", Read, true},
+		{"alice", "public", Write, false},
+		{"bob", "scores", Read, false},
+		{"", "public", Read, true},
+		{"ops", "scores", Read | Write, true},
+	}
+	for _, tt := range tests {
+		err := rules.Authorize(tt.principal, tt.group, tt.access)
+		if (err == nil) != tt.allowed || (err != nil && !errors.Is(err, ErrForbidden)) {
+			t.Errorf("%s %s %v: expected allowed %v, got %v", tt.principal, tt.group, tt.access, tt.allowed, err)
+		}
+	}
+
+	var access Access
+	if err := access.UnmarshalText([]byte("rw")); err != nil || access != Read|Write || access.String() != "rw" {
+		t.Fatalf("expected rw, got %v, %v", access, err)
+	}
+	if err := access.UnmarshalText([]byte("x")); err == nil {
+		t.Fatal("expected an invalid access to fail")
+	}
+}
+
+// TestHTTPPoolAuth tests that peers sign their requests, and that the
+// peer and membership routes refuse requests that are not.
+func TestHTTPPoolAuth(t *testing.T) {
+	g := NewGroup("auth", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	defer g.Close()
+
+	auth := NewHMACAuth("cluster", []byte("secret"))
+	pool := NewHTTPPool("")
+	pool.SetAuthenticator(auth)
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+
+	get := func(auth Authenticator) error {
+		res := &pb.Response{}
+		return newHTTPGetter(srv.URL, defaultBasePath, nil, auth).Get(&pb.Request{Group: "auth", Key: "k1"}, res)
+	}
+	if err := get(auth); err != nil {
+		t.Fatalf("expected the signed get to succeed, got %v", err)
+	}
+	var se *serverError
+	if err := get(nil); !errors.As(err, &se) || se.status != "401 Unauthorized" {
+		t.Fatalf("expected the unsigned get to be refused, got %v", err)
+	}
+
+	// the pool signs what it sends to its peers
+	pool.Set(srv.URL)
+	if getter := pool.httpGetters[srv.URL]; getter.Get(&pb.Request{Group: "auth", Key: "k2"}, &pb.Response{}) != nil {
+		t.Fatal("expected the pool's getter to sign its requests")
+	}
+
+	res, err := http.Post(srv.URL+"/set-peers", "application/json", strings.NewReader(`["http://intruder"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized || pool.Peers()[0] != srv.URL {
+		t.Fatalf("expected the unsigned membership update to be refused, got %d, %v", res.StatusCode, pool.Peers())
+	}
+	client := &http.Client{Transport: NewAuthTransport(auth, nil)}
+	res, err = client.Post(srv.URL+"/set-peers", "application/json", strings.NewReader(`["`+srv.URL+`","http://localhost:8002"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || len(pool.Peers()) != 2 {
+		t.Fatalf("expected the signed membership update to be applied, got %d, %v", res.StatusCode, pool.Peers())
+	}
+
+	// the health check stays open
+	res, err = http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the health check to succeed, got %d", res.StatusCode)
+	}
+}
+
+// TestAdminAuth tests that the admin API authenticates its requests and
+// checks the principal's access to each group.
+func TestAdminAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g := NewGroup("admin-auth", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	defer g.Close()
+	g.Get("k1")
+
+	admin := NewAdmin(NewHTTPPool("http://localhost:8001"))
+	admin.SetAuthenticator(&BearerAuth{Tokens: map[string]string{"t-alice": "alice", "t-bob": "bob"}})
+	admin.SetAuthorizer(Rules{
+		{Principal: "alice", Group: "admin-auth", Access: Read | Write},
+		{Principal: "bob", Group: "admin-auth", Access: Read},
+	})
+	r := gin.New()
+	admin.LoadRouters(r)
+
+	request := func(token, method, path string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader("v"))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	tests := []struct {
+		token, method, path string
+		code                int
+	}{
+		{"", "GET", "/_admin/groups", http.StatusUnauthorized},
+		{"t-eve", "GET", "/_admin/groups/admin-auth", http.StatusUnauthorized},
+		{"t-bob", "GET", "/_admin/groups/admin-auth/keys/k1", http.StatusOK},
+		{"t-bob", "DELETE", "/_admin/groups/admin-auth/keys/k1", http.StatusForbidden},
+		{"t-bob", "GET", "/_admin/groups/scores", http.StatusForbidden},
+		{"t-alice", "PUT", "/_admin/groups/admin-auth/keys/k2", http.StatusOK},
+		{"t-alice", "DELETE", "/_admin/groups/admin-auth/keys/k1", http.StatusOK},
+		{"t-alice", "GET", "/_admin/peers", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := request(tt.token, tt.method, tt.path); code != tt.code {
+			t.Errorf("%s %s with %q: expected %d, got %d", tt.method, tt.path, tt.token, tt.code, code)
+		}
+	}
+
+	// other groups are left out of what bob may list
+	other := NewGroup("admin-auth-other", 0, GetterFunc(func(key string) ([]byte, error) {
+		return nil, nil
+	}))
+	defer other.Close()
+	for _, path := range []string{"/_admin/groups", "/_admin/config"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer t-bob")
+		r.ServeHTTP(w, req)
+		if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, `"admin-auth"`) || strings.Contains(body, "admin-auth-other") {
+			t.Errorf("%s: expected only admin-auth, got %d %s", path, w.Code, body)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package gocache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	memoryBudget atomic.Int64
+	// budgetMu serializes enforcement so concurrent adds don't all evict
+	// for the same overflow.
+	budgetMu sync.Mutex
+)
+
+// SetMemoryBudget caps the memory used by the caches of all groups
+// together at bytes, on top of each group's own cacheBytes. 0 removes
+// the cap. Lowering the budget evicts entries right away.
+func SetMemoryBudget(bytes int64) {
+	memoryBudget.Store(bytes)
+	enforceMemoryBudget()
+}
+
+// MemoryBudget returns the process-wide memory budget, 0 if there is none.
+func MemoryBudget() int64 {
+	return memoryBudget.Load()
+}
+
+// MemoryUsage returns the memory used by the caches of all groups.
+func MemoryUsage() int64 {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var total int64
+	for _, g := range groups {
+		total += g.mainCache.bytes()
+	}
+	return total
+}
+
+// enforceMemoryBudget evicts entries until all groups fit the budget.
+func enforceMemoryBudget() {
+	limit := memoryBudget.Load()
+	if limit <= 0 {
+		return
+	}
+
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+
+	for MemoryUsage() > limit {
+		victim := budgetVictim(limit)
+		if victim == nil || !victim.mainCache.removeOldest() {
+			return
+		}
+	}
+}
+
+// budgetVictim picks the group to evict from: the one using the largest
+// share of its own cacheBytes, so every group gives back memory in
+// proportion to its size. Unbounded groups are weighed against the budget.
+func budgetVictim(limit int64) *Group {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var (
+		victim *Group
+		worst  float64
+	)
+	for _, g := range groups {
+		used := g.mainCache.bytes()
+		if used == 0 {
+			continue
+		}
+		_, weight := g.mainCache.stats()
+		if weight <= 0 || weight > limit {
+			weight = limit
+		}
+		if share := float64(used) / float64(weight); victim == nil || share > worst {
+			victim, worst = g, share
+		}
+	}
+	return victim
+}
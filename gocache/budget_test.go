@@ -0,0 +1,73 @@
+package gocache
+
+import (
+	"fmt"
+	"gocache/lru"
+	"strings"
+	"testing"
+)
+
+// TestMemoryBudget tests that groups together stay within the budget and
+// share the eviction pressure.
+func TestMemoryBudget(t *testing.T) {
+	value := strings.Repeat("x", 1<<10)
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(value), nil
+	})
+	small := NewGroup("budget-small", 64<<10, getter)
+	large := NewGroup("budget-large", 128<<10, getter)
+	defer small.Close()
+	defer large.Close()
+
+	const budget = 48 << 10
+	SetMemoryBudget(budget)
+	defer SetMemoryBudget(0)
+
+	for i := 0; i < 100; i++ {
+		small.Get(fmt.Sprint(i))
+		large.Get(fmt.Sprint(i))
+	}
+
+	if used := MemoryUsage(); used > budget {
+		t.Fatalf("memory usage %d exceeds budget %d", used, budget)
+	}
+	s, l := small.mainCache.bytes(), large.mainCache.bytes()
+	if s == 0 || l == 0 || l < s {
+		t.Fatalf("expected pressure in proportion to size, got small=%d large=%d", s, l)
+	}
+}
+
+// TestEntryOverhead tests that a cached value is accounted for more than
+// its key and data.
+func TestEntryOverhead(t *testing.T) {
+	g := NewGroup("overhead", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("630"), nil
+	}))
+	defer g.Close()
+	g.Get("Tom")
+	if used := g.mainCache.bytes(); used <= int64(len("Tom")+len("630")) {
+		t.Fatalf("expected per-entry overhead to be accounted, got %d bytes", used)
+	}
+}
+
+// TestEntryBytes tests an entry is accounted exactly once for each policy.
+func TestEntryBytes(t *testing.T) {
+	for _, policy := range []EvictionPolicy{EvictLRU, EvictClock} {
+		g := NewGroup("entry-bytes", 0, MetaGetterFunc(func(key string) ([]byte, Meta, error) {
+			return []byte("x"), Meta{ContentType: "text/plain"}, nil
+		}))
+		g.SetEvictionPolicy(policy)
+		g.Get("k")
+
+		var empty store = lru.NewTyped[string, ByteView](0, nil, nil)
+		if policy == EvictClock {
+			empty = lru.NewClock[string, ByteView](0, nil, nil)
+		}
+		empty.Add("", ByteView{})
+		want := empty.Bytes() + int64(len("k")+len("x")+len("text/plain"))
+		if used := g.mainCache.bytes(); used != want {
+			t.Errorf("policy %d: expected %d bytes, got %d", policy, want, used)
+		}
+		g.Close()
+	}
+}
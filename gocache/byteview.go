@@ -1,23 +1,111 @@
 package gocache
 
+import (
+	"time"
+	"unsafe"
+)
+
 // A ByteView holds an immutable view of bytes.
+// Internally it wraps either a []byte or a string,
+// but that detail is invisible to callers.
 type ByteView struct {
-	b []byte
+	// If b is non-nil, b is used, else s is used.
+	b      []byte
+	s      string
+	enc    Algorithm // compression of b, visible only inside the package
+	meta   Meta
+	expire time.Time // zero means the view never expires
 }
 
 // Len returns the view's length.
 func (v ByteView) Len() int {
-	return len(v.b)
+	if v.b != nil {
+		return len(v.b)
+	}
+	return len(v.s)
 }
 
 // ByteSlice returns a copy of the data as a byte slice.
 func (v ByteView) ByteSlice() []byte {
-	return cloneBytes(v.b)
+	if v.b != nil {
+		return cloneBytes(v.b)
+	}
+	return []byte(v.s)
 }
 
 // String returns the data as a string, making a copy if necessary.
 func (v ByteView) String() string {
-	return string(v.b)
+	if v.b != nil {
+		return string(v.b)
+	}
+	return v.s
+}
+
+// Size returns the memory the view occupies, its header included.
+func (v ByteView) Size() int64 {
+	return int64(unsafe.Sizeof(v)) + int64(v.Len()) + int64(len(v.meta.ContentType))
+}
+
+// Copy copies b into dest and returns the number of bytes copied.
+func (v ByteView) Copy(dest []byte) int {
+	if v.b != nil {
+		return copy(dest, v.b)
+	}
+	return copy(dest, v.s)
+}
+
+// Meta returns the metadata the loader reported for the value.
+func (v ByteView) Meta() Meta {
+	return v.meta
+}
+
+// Expire returns the time at which the view expires, or the zero time
+// if it never does.
+func (v ByteView) Expire() time.Time {
+	return v.expire
+}
+
+// TTL returns the time left before the view expires, or 0 if it never does.
+// A view that has already expired reports the smallest positive duration so
+// it is never mistaken for one without expiry.
+func (v ByteView) TTL() time.Duration {
+	if v.expire.IsZero() {
+		return 0
+	}
+	if d := v.expire.Sub(nowFunc()); d > 0 {
+		return d
+	}
+	return time.Nanosecond
+}
+
+// bytes returns the data as a byte slice without copying it when the view
+// is backed by one. The result must not be modified.
+func (v ByteView) bytes() []byte {
+	if v.b != nil {
+		return v.b
+	}
+	return []byte(v.s)
+}
+
+// expired reports whether the view has expired at now.
+func (v ByteView) expired(now time.Time) bool {
+	return !v.expire.IsZero() && !now.Before(v.expire)
+}
+
+// sameData reports whether v and o share the same underlying data.
+func (v ByteView) sameData(o ByteView) bool {
+	if v.Len() != o.Len() {
+		return false
+	}
+	switch {
+	case v.Len() == 0:
+		return true
+	case v.b != nil && o.b != nil:
+		return &v.b[0] == &o.b[0]
+	case v.b == nil && o.b == nil:
+		return unsafe.StringData(v.s) == unsafe.StringData(o.s)
+	}
+	return false
 }
 
 // cloneBytes returns a copy of b.
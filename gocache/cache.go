@@ -2,13 +2,51 @@ package gocache
 
 import (
 	"gocache/lru"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// EvictionPolicy selects the structure a cache evicts with.
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entry exactly. Every hit
+	// reorders entries, so reads take the cache's lock exclusively.
+	EvictLRU EvictionPolicy = iota
+	// EvictClock approximates LRU with the CLOCK algorithm. A hit only
+	// sets a bit atomically, so reads share the cache's lock.
+	EvictClock
+)
+
+// store is the eviction structure behind a cache, see package lru.
+type store interface {
+	Get(key string) (ByteView, bool)
+	Peek(key string) (ByteView, bool)
+	Add(key string, value ByteView)
+	Remove(key string) bool
+	RemoveOldest()
+	Range(f func(key string, value ByteView) bool)
+	Purge()
+	Resize(maxBytes int64)
+	Len() int
+	Bytes() int64
+}
+
 type cache struct {
-	mu         sync.Mutex
-	lru        *lru.Cache
+	mu         sync.RWMutex
+	store      store
+	policy     EvictionPolicy
 	cacheBytes int64
+	closed     bool         // set by close, adds are dropped afterwards
+	nbytes     atomic.Int64 // mirrors store.Bytes, readable without mu
+}
+
+// sizeOfView counts the memory a key and its view point to. The view header
+// is stored inline in the entry, which the store already accounts for.
+func sizeOfView(key string, value ByteView) int64 {
+	return int64(len(key)) + int64(value.Len()) + int64(len(value.meta.ContentType))
 }
 
 // add adds a value to the cache.
@@ -16,25 +54,199 @@ func (c *cache) add(key string, value ByteView) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.closed {
+		return
+	}
 	// lazy initialization
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+	if c.store == nil {
+		if c.policy == EvictClock {
+			c.store = lru.NewClock[string, ByteView](c.cacheBytes, sizeOfView, nil)
+		} else {
+			c.store = lru.NewTyped[string, ByteView](c.cacheBytes, sizeOfView, nil)
+		}
 	}
-	c.lru.Add(key, value)
+	c.store.Add(key, value)
+	c.nbytes.Store(c.store.Bytes())
 }
 
 // get look up a key's value.
 func (c *cache) get(key string) (value ByteView, ok bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// the policy may change, so it is read under the lock; the exclusive
+	// lock an LRU hit needs is safe for any policy
+	c.mu.RLock()
+	if c.policy == EvictClock {
+		defer c.mu.RUnlock()
+	} else {
+		c.mu.RUnlock()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+
+	if c.store == nil {
+		return
+	}
+
+	if view, ok := c.store.Get(key); ok {
+		// expired entries are left for the next add to overwrite
+		if !view.expired(nowFunc()) {
+			return view, ok
+		}
+	}
+
+	return
+}
+
+// peek looks up a key's value without updating its recency.
+func (c *cache) peek(key string) (value ByteView, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-	if c.lru == nil {
+	if c.store == nil {
 		return
 	}
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+	if view, ok := c.store.Peek(key); ok {
+		if !view.expired(nowFunc()) {
+			return view, ok
+		}
 	}
 
 	return
 }
+
+// touch changes the expiry of a key's value, reporting whether it was
+// cached. A zero expire makes it never expire.
+func (c *cache) touch(key string, ttl time.Duration, expire time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store == nil {
+		return false
+	}
+	view, ok := c.store.Peek(key)
+	if !ok || view.expired(nowFunc()) {
+		return false
+	}
+	view.meta.TTL = ttl
+	view.expire = expire
+	c.store.Add(key, view)
+	return true
+}
+
+// removeOldest evicts the least recently used entry, reporting whether
+// there was one.
+func (c *cache) removeOldest() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store == nil || c.store.Len() == 0 {
+		return false
+	}
+	c.store.RemoveOldest()
+	c.nbytes.Store(c.store.Bytes())
+	return true
+}
+
+// remove removes a key, reporting whether it was present.
+func (c *cache) remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store == nil {
+		return false
+	}
+	ok := c.store.Remove(key)
+	c.nbytes.Store(c.store.Bytes())
+	return ok
+}
+
+// resize changes the memory limit of the cache, evicting entries right
+// away when it shrinks.
+func (c *cache) resize(cacheBytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cacheBytes = cacheBytes
+	if c.store != nil {
+		c.store.Resize(cacheBytes)
+		c.nbytes.Store(c.store.Bytes())
+	}
+}
+
+// stats returns the number of entries and the memory limit of the cache.
+func (c *cache) stats() (items int, cacheBytes int64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.store != nil {
+		items = c.store.Len()
+	}
+	return items, c.cacheBytes
+}
+
+// scan pages through the cached keys, see lru.Cache.Scan. Only copying
+// the matching keys holds the lock; picking and sorting the page does not.
+func (c *cache) scan(prefix, cursor string, limit int) ([]string, string) {
+	var matched keySnapshot
+	c.mu.RLock()
+	if c.store != nil {
+		c.store.Range(func(key string, _ ByteView) bool {
+			if key > cursor && strings.HasPrefix(key, prefix) {
+				matched = append(matched, key)
+			}
+			return true
+		})
+	}
+	c.mu.RUnlock()
+
+	return lru.Scan[struct{}](matched, prefix, cursor, limit)
+}
+
+// keySnapshot ranges over keys copied out of a cache.
+type keySnapshot []string
+
+// Range implements lru.Ranger interface.
+func (s keySnapshot) Range(f func(key string, value struct{}) bool) {
+	for _, key := range s {
+		if !f(key, struct{}{}) {
+			return
+		}
+	}
+}
+
+// purge drops all entries.
+func (c *cache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store != nil {
+		c.store.Purge()
+	}
+	c.nbytes.Store(0)
+}
+
+// close drops all entries for good: values added afterwards, e.g. by
+// loads that were in flight, are dropped too.
+func (c *cache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	c.store = nil
+	c.nbytes.Store(0)
+}
+
+// setPolicy switches the eviction policy, dropping all entries.
+func (c *cache) setPolicy(policy EvictionPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.policy = policy
+	c.store = nil
+	c.nbytes.Store(0)
+}
+
+// bytes returns the memory used by the cache.
+func (c *cache) bytes() int64 {
+	return c.nbytes.Load()
+}
@@ -0,0 +1,76 @@
+package gocache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestCacheEvictionPolicy tests that both policies serve hits and evict
+func TestCacheEvictionPolicy(t *testing.T) {
+	for _, policy := range []EvictionPolicy{EvictLRU, EvictClock} {
+		c := &cache{policy: policy}
+		c.add("k1", ByteView{b: []byte("v1")})
+		c.add("k2", ByteView{b: []byte("v2")})
+		if v, ok := c.get("k1"); !ok || v.String() != "v1" {
+			t.Fatalf("policy %d: cache hit k1=v1 failed", policy)
+		}
+		c.add("k3", ByteView{b: []byte("v3")})
+		c.removeOldest()
+		if _, ok := c.get("k2"); ok {
+			t.Fatalf("policy %d: expected k2 to be evicted", policy)
+		}
+		if _, ok := c.get("k1"); !ok {
+			t.Fatalf("policy %d: expected recently read k1 to survive", policy)
+		}
+	}
+}
+
+// TestCacheSetPolicyConcurrent tests that the policy can be switched while
+// the cache is read, run it with -race.
+func TestCacheSetPolicyConcurrent(t *testing.T) {
+	c := &cache{}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.add("k1", ByteView{b: []byte("v1")})
+				c.get("k1")
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		c.setPolicy(EvictionPolicy(i % 2))
+	}
+	wg.Wait()
+}
+
+// benchmarkCacheGetParallel reads a warm cache from all procs at once, so the
+// cost is dominated by contention on the cache's lock.
+func benchmarkCacheGetParallel(b *testing.B, policy EvictionPolicy) {
+	c := &cache{policy: policy}
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+		c.add(keys[i], ByteView{b: []byte("value")})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c.get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkCacheGetParallelLRU(b *testing.B) {
+	benchmarkCacheGetParallel(b, EvictLRU)
+}
+
+func BenchmarkCacheGetParallelClock(b *testing.B) {
+	benchmarkCacheGetParallel(b, EvictClock)
+}
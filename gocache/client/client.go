@@ -0,0 +1,505 @@
+// Package client is a Go client for a gocache cluster. It keeps the same
+// consistent hash ring as the nodes and sends every request straight to
+// the node that owns the key, saving the hop through a node's /api.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gocache"
+	pb "gocache/cachepb"
+	"gocache/consistenthash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	basePath  = "/_gocache/"
+	adminPath = "/_admin/"
+
+	defaultRefreshInterval = 5 * time.Second
+	defaultReplicas        = 2
+	defaultRingReplicas    = 50 // virtual nodes per node, as on the nodes
+	maxGetsPerNode         = 8  // concurrent gets GetMany sends a node
+)
+
+// Options configures a Client. The zero value is usable.
+type Options struct {
+	// HTTPClient sends the requests, by default one with a 10s timeout.
+	// It needs a transport from gocache.NewNodeTransport, or one wrapping
+	// it, to reach nodes on Unix domain sockets.
+	HTTPClient *http.Client
+	// RefreshInterval is how often the membership is fetched again, by
+	// default every 5s. A negative interval only fetches it in New and
+	// on Refresh.
+	RefreshInterval time.Duration
+	// Replicas is the number of nodes tried for a key, counting its
+	// owner, by default 2. The nodes after the owner are its successors
+	// on the ring, which stand in for the owner while it is down.
+	Replicas int
+}
+
+// Value is a value read from the cluster and the metadata it was loaded
+// with. Meta.TTL is the time the value has left to live.
+type Value struct {
+	Data []byte
+	Meta gocache.Meta
+}
+
+// ServerError is returned for a request a node answered with an error
+// that has no typed counterpart, e.g. because its getter failed.
+type ServerError struct {
+	Node    string
+	Status  int
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("client: %s answered %d: %s", e.Node, e.Status, e.Message)
+}
+
+// GetManyError is returned by GetMany when some keys could not be read.
+type GetManyError struct {
+	Errors map[string]error // keyed by key
+}
+
+func (e *GetManyError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("client: getting %d keys failed, first %s: %v", len(keys), keys[0], e.Errors[keys[0]])
+}
+
+// Client routes requests to the nodes of a cluster. It is safe for
+// concurrent use.
+type Client struct {
+	seeds    []string
+	hc       *http.Client
+	replicas int
+
+	mu    sync.RWMutex // guards peers and ring
+	peers []string
+	ring  *consistenthash.Map
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a client for the cluster the seed nodes belong to, e.g.
+// "http://localhost:8001". It fetches the membership from the first seed
+// that answers, and keeps it up to date in the background until Close.
+// Set and Delete use the admin API, which must be served on the nodes'
+// own addresses.
+func New(seeds []string, opts *Options) (*Client, error) {
+	if len(seeds) == 0 {
+		return nil, errors.New("client: no seed nodes")
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	c := &Client{
+		seeds:    append([]string(nil), seeds...),
+		hc:       opts.HTTPClient,
+		replicas: opts.Replicas,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if c.hc == nil {
+		c.hc = &http.Client{Timeout: 10 * time.Second, Transport: gocache.NewNodeTransport(nil)}
+	}
+	if c.replicas <= 0 {
+		c.replicas = defaultReplicas
+	}
+	if err := c.Refresh(); err != nil {
+		return nil, err
+	}
+
+	interval := opts.RefreshInterval
+	if interval == 0 {
+		interval = defaultRefreshInterval
+	}
+	if interval > 0 {
+		go c.watch(interval)
+	} else {
+		close(c.done)
+	}
+	return c, nil
+}
+
+// Close stops refreshing the membership.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.stop) })
+	<-c.done
+	return nil
+}
+
+// watch refreshes the membership every interval until Close.
+func (c *Client) watch(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Refresh(); err != nil {
+				log.Println("client: refresh membership:", err)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// peersInfo mirrors the admin API's reply for /peers.
+type peersInfo struct {
+	Self     string   `json:"self"`
+	Peers    []string `json:"peers"`
+	Replicas int      `json:"replicas"`
+}
+
+// Refresh fetches the membership from the first node that answers, the
+// known peers first and then the seeds, and rebuilds the ring if it
+// changed.
+func (c *Client) Refresh() error {
+	var err error
+	for _, node := range c.candidates() {
+		var info peersInfo
+		if err = c.doJSON(http.MethodGet, gocache.NodeURL(node)+adminPath+"peers", nil, "", &info); err != nil {
+			continue
+		}
+		peers := info.Peers
+		if len(peers) == 0 {
+			// a node without peers serves every key itself
+			peers = []string{info.Self}
+		}
+		c.setPeers(peers, info.Replicas)
+		return nil
+	}
+	return fmt.Errorf("client: no node answered: %w", err)
+}
+
+// candidates returns the nodes to ask for the membership.
+func (c *Client) candidates() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	nodes := append([]string(nil), c.peers...)
+	for _, seed := range c.seeds {
+		if !contains(nodes, seed) {
+			nodes = append(nodes, seed)
+		}
+	}
+	return nodes
+}
+
+func (c *Client) setPeers(peers []string, replicas int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sorted := append([]string(nil), peers...)
+	sort.Strings(sorted)
+	if c.ring != nil && equal(sorted, c.peers) {
+		return
+	}
+	if replicas <= 0 {
+		replicas = defaultRingReplicas
+	}
+	// add the peers in the node's order, so that even colliding virtual
+	// nodes resolve the same way
+	c.ring = consistenthash.New(replicas, nil)
+	c.ring.Add(peers...)
+	c.peers = sorted
+}
+
+// Peers returns the nodes of the cluster, sorted.
+func (c *Client) Peers() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.peers...)
+}
+
+// Owner returns the node that owns key.
+func (c *Client) Owner(key string) string {
+	if nodes := c.nodes(key); len(nodes) > 0 {
+		return nodes[0]
+	}
+	return ""
+}
+
+// nodes returns the owner of key followed by the nodes standing in for it.
+func (c *Client) nodes(key string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.GetN(key, c.replicas)
+}
+
+// Get returns the value of key in group from the node that owns it. If
+// the owner cannot be reached, the next replica is asked to load the key
+// in its place. Like gocache.Group.Get, Get returns a
+// gocache.LoadingError or gocache.OverloadedError when the node asks to
+// be retried later.
+func (c *Client) Get(group, key string) (Value, error) {
+	nodes := c.nodes(key)
+	if len(nodes) == 0 {
+		return Value{}, gocache.ErrNoPeers
+	}
+
+	var err error
+	for i, node := range nodes {
+		var value Value
+		if value, err = c.get(node, group, key, i > 0); !unreachable(err) {
+			return value, err
+		}
+	}
+	return Value{}, err
+}
+
+func (c *Client) get(node, group, key string, fallback bool) (Value, error) {
+	u := gocache.NodeURL(node) + basePath + url.PathEscape(group) + "/" + url.PathEscape(key)
+	if fallback {
+		u += "?fallback=1"
+	}
+	res, err := c.hc.Get(u)
+	if err != nil {
+		return Value{}, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Value{}, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return Value{}, statusError(node, res, data)
+	}
+
+	out := &pb.Response{}
+	if err := proto.Unmarshal(data, out); err != nil {
+		return Value{}, fmt.Errorf("client: decoding response of %s: %v", node, err)
+	}
+	if ms := out.GetRetryAfter(); ms > 0 {
+		return Value{}, &gocache.LoadingError{RetryAfter: time.Duration(ms) * time.Millisecond}
+	}
+	return Value{
+		Data: out.GetValue(),
+		Meta: gocache.Meta{
+			TTL:         time.Duration(out.GetTtl()) * time.Millisecond,
+			Version:     out.GetVersion(),
+			ContentType: out.GetContentType(),
+			Cost:        out.GetCost(),
+			Flags:       out.GetFlags(),
+		},
+	}, nil
+}
+
+// GetMany gets several keys of group, asking the owners concurrently and
+// each owner for up to maxGetsPerNode keys at once. It returns the values
+// it could read and, if some keys failed, a *GetManyError with their
+// errors.
+func (c *Client) GetMany(group string, keys []string) (map[string]Value, error) {
+	seen := make(map[string]bool, len(keys))
+	byNode := make(map[string][]string)
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			owner := c.Owner(key)
+			byNode[owner] = append(byNode[owner], key)
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		values = make(map[string]Value, len(seen))
+		errs   = make(map[string]error)
+	)
+	for _, keys := range byNode {
+		wg.Add(1)
+		go func(keys []string) {
+			defer wg.Done()
+			slots := make(chan struct{}, maxGetsPerNode)
+			for _, key := range keys {
+				slots <- struct{}{}
+				wg.Add(1)
+				go func(key string) {
+					defer func() {
+						<-slots
+						wg.Done()
+					}()
+					value, err := c.Get(group, key)
+					mu.Lock()
+					if err != nil {
+						errs[key] = err
+					} else {
+						values[key] = value
+					}
+					mu.Unlock()
+				}(key)
+			}
+		}(keys)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return values, &GetManyError{Errors: errs}
+	}
+	return values, nil
+}
+
+// Set stores value under key in group on the node that owns it, or on the
+// replica standing in for the owner if it cannot be reached.
+func (c *Client) Set(group, key string, value []byte, meta gocache.Meta) error {
+	nodes := c.nodes(key)
+	if len(nodes) == 0 {
+		return gocache.ErrNoPeers
+	}
+
+	q := url.Values{}
+	if meta.TTL > 0 {
+		q.Set("ttl", meta.TTL.String())
+	}
+	if meta.Version != 0 {
+		q.Set("version", strconv.FormatInt(meta.Version, 10))
+	}
+	var err error
+	for _, node := range nodes {
+		u := entryURL(node, group, key)
+		if len(q) > 0 {
+			u += "?" + q.Encode()
+		}
+		err = c.doJSON(http.MethodPut, u, bytes.NewReader(value), meta.ContentType, nil)
+		if !unreachable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// Delete removes key in group from the node that owns it and from the
+// replicas, which may have cached it while standing in for the owner. It
+// returns the owner's error, if any.
+func (c *Client) Delete(group, key string) error {
+	nodes := c.nodes(key)
+	if len(nodes) == 0 {
+		return gocache.ErrNoPeers
+	}
+
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			errs[i] = c.doJSON(http.MethodDelete, entryURL(node, group, key), nil, "", nil)
+		}(i, node)
+	}
+	wg.Wait()
+	return errs[0]
+}
+
+func entryURL(node, group, key string) string {
+	return gocache.NodeURL(node) + adminPath + "groups/" + url.PathEscape(group) + "/keys/" + url.PathEscape(key)
+}
+
+// doJSON sends a request to the admin API and decodes its reply into out,
+// unless out is nil.
+func (c *Client) doJSON(method, u string, body io.Reader, contentType string, out interface{}) error {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return statusError(req.URL.Scheme+"://"+req.URL.Host, res, data)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// knownErrors are the typed errors nodes answer with, by message.
+var knownErrors = []error{
+	gocache.ErrNoSuchGroup,
+	gocache.ErrGroupClosed,
+}
+
+// statusError turns a reply other than 200 OK into the server's typed
+// error where there is one.
+func statusError(node string, res *http.Response, body []byte) error {
+	if res.StatusCode == http.StatusServiceUnavailable {
+		retry := time.Second
+		if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			retry = time.Duration(secs) * time.Second
+		}
+		return &gocache.OverloadedError{RetryAfter: retry}
+	}
+
+	msg := strings.TrimSpace(string(body))
+	var e struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		msg = e.Error
+	}
+	for _, known := range knownErrors {
+		if msg == known.Error() {
+			return fmt.Errorf("client: %s: %w", node, known)
+		}
+	}
+	return &ServerError{Node: node, Status: res.StatusCode, Message: msg}
+}
+
+// unreachable reports whether err means the node could not be reached, so
+// that another node should be tried.
+func unreachable(err error) bool {
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
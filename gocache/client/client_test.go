@@ -0,0 +1,259 @@
+package client
+
+import (
+	"errors"
+	"gocache"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// node is a cache node served over loopback. All nodes of a test share the
+// process's groups, so the tests tell them apart by the requests they get.
+type node struct {
+	*httptest.Server
+	pool *gocache.HTTPPool
+
+	mu   sync.Mutex
+	reqs []*http.Request
+}
+
+func startNode(t *testing.T) *node {
+	gin.SetMode(gin.TestMode)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &node{pool: gocache.NewHTTPPool("http://" + l.Addr().String())}
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		n.mu.Lock()
+		n.reqs = append(n.reqs, c.Request)
+		n.mu.Unlock()
+	})
+	n.pool.LoadRouters(r)
+	gocache.NewAdmin(n.pool).LoadRouters(r)
+
+	n.Server = &httptest.Server{Listener: l, Config: &http.Server{Handler: r}}
+	n.Start()
+	t.Cleanup(n.Close)
+	return n
+}
+
+// requests returns the requests the node got and forgets them.
+func (n *node) requests() []*http.Request {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	reqs := n.reqs
+	n.reqs = nil
+	return reqs
+}
+
+// startCluster starts count nodes that all know each other.
+func startCluster(t *testing.T, count int) map[string]*node {
+	nodes := make(map[string]*node, count)
+	var addrs []string
+	for i := 0; i < count; i++ {
+		n := startNode(t)
+		nodes[n.URL] = n
+		addrs = append(addrs, n.URL)
+	}
+	for _, n := range nodes {
+		n.pool.Set(addrs...)
+	}
+	return nodes
+}
+
+func newGroup(t *testing.T, name string) *gocache.Group {
+	g := gocache.NewGroup(name, 0, gocache.GetterFunc(func(key string) ([]byte, error) {
+		if key == "bad" {
+			return nil, errors.New("no such key")
+		}
+		return []byte("value of " + key), nil
+	}))
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+// TestGet tests that gets go straight to the owner, and to the next
+// replica when the owner is down.
+func TestGet(t *testing.T) {
+	nodes := startCluster(t, 3)
+	newGroup(t, "client-get")
+
+	var seed string
+	for addr := range nodes {
+		seed = addr
+	}
+	c, err := New([]string{seed}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if peers := c.Peers(); len(peers) != 3 {
+		t.Fatalf("expected 3 peers, got %v", peers)
+	}
+
+	for i := 0; i < 10; i++ {
+		key := "k" + strconv.Itoa(i)
+		for _, n := range nodes {
+			n.requests()
+		}
+		value, err := c.Get("client-get", key)
+		if err != nil || string(value.Data) != "value of "+key {
+			t.Fatalf("expected the value of %s, got %q, %v", key, value.Data, err)
+		}
+		owner := c.Owner(key)
+		if owner != nodes[owner].pool.Owner(key) {
+			t.Fatalf("client and node disagree on the owner of %s", key)
+		}
+		for addr, n := range nodes {
+			if got := len(n.requests()); (addr == owner) != (got == 1) {
+				t.Fatalf("expected only the owner %s to be asked for %s, %s got %d requests", owner, key, addr, got)
+			}
+		}
+	}
+
+	// take the owner of k0 down
+	owner := nodes[c.Owner("k0")]
+	owner.Close()
+	if value, err := c.Get("client-get", "k0"); err != nil || string(value.Data) != "value of k0" {
+		t.Fatalf("expected a replica to serve k0, got %q, %v", value.Data, err)
+	}
+	next := nodes[c.nodes("k0")[1]]
+	if reqs := next.requests(); len(reqs) != 1 || reqs[0].URL.Query().Get("fallback") == "" {
+		t.Fatalf("expected the replica to be asked to stand in, got %v", reqs)
+	}
+}
+
+// TestGetMany tests that GetMany returns what it could read along with
+// the errors of the other keys.
+func TestGetMany(t *testing.T) {
+	nodes := startCluster(t, 2)
+	newGroup(t, "client-many")
+
+	var seeds []string
+	for addr := range nodes {
+		seeds = append(seeds, addr)
+	}
+	c, err := New(seeds, &Options{RefreshInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	keys := []string{"k1", "k2", "k3", "bad", "k1"}
+	values, err := c.GetMany("client-many", keys)
+	var many *GetManyError
+	if !errors.As(err, &many) || len(many.Errors) != 1 || many.Errors["bad"] == nil {
+		t.Fatalf("expected bad to fail, got %v", err)
+	}
+	var se *ServerError
+	if !errors.As(many.Errors["bad"], &se) || se.Message != "no such key" {
+		t.Fatalf("expected the getter's error, got %v", many.Errors["bad"])
+	}
+	if len(values) != 3 || string(values["k2"].Data) != "value of k2" {
+		t.Fatalf("unexpected values %v", values)
+	}
+}
+
+// TestSetDelete tests that Set and Delete act on the owner.
+func TestSetDelete(t *testing.T) {
+	nodes := startCluster(t, 2)
+	g := newGroup(t, "client-set")
+
+	var seed string
+	for addr := range nodes {
+		seed = addr
+	}
+	c, err := New([]string{seed}, &Options{RefreshInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	meta := gocache.Meta{TTL: time.Minute, Version: 3, ContentType: "text/plain"}
+	if err := c.Set("client-set", "k1", []byte("set"), meta); err != nil {
+		t.Fatal(err)
+	}
+	value, err := c.Get("client-set", "k1")
+	if err != nil || string(value.Data) != "set" || value.Meta.Version != 3 || value.Meta.ContentType != "text/plain" || value.Meta.TTL <= 0 {
+		t.Fatalf("expected the set value and meta, got %+v, %v", value, err)
+	}
+
+	if err := c.Delete("client-set", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := g.Lookup("k1"); ok {
+		t.Fatalf("expected k1 to be deleted")
+	}
+}
+
+// TestErrors tests that the server's typed errors reach the caller.
+func TestErrors(t *testing.T) {
+	nodes := startCluster(t, 1)
+	g := newGroup(t, "client-errors")
+
+	var seed string
+	for addr := range nodes {
+		seed = addr
+	}
+	c, err := New([]string{seed}, &Options{RefreshInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Get("client-unknown", "k1"); !errors.Is(err, gocache.ErrNoSuchGroup) {
+		t.Fatalf("expected ErrNoSuchGroup, got %v", err)
+	}
+	if err := c.Set("client-unknown", "k1", nil, gocache.Meta{}); !errors.Is(err, gocache.ErrNoSuchGroup) {
+		t.Fatalf("expected ErrNoSuchGroup from Set, got %v", err)
+	}
+
+	g.SetLoadLimits(gocache.LoadLimits{Rate: 1, Burst: 1})
+	c.Get("client-errors", "k1")
+	_, err = c.Get("client-errors", "k2")
+	if retry, ok := gocache.RetryAfter(err); !errors.Is(err, gocache.ErrOverloaded) || !ok || retry <= 0 {
+		t.Fatalf("expected an OverloadedError, got %v", err)
+	}
+
+	if _, err := New([]string{"http://127.0.0.1:1"}, nil); err == nil {
+		t.Fatalf("expected New to fail without a reachable seed")
+	}
+}
+
+// TestUnixSocket tests that the client reaches nodes on Unix domain
+// sockets.
+func TestUnixSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newGroup(t, "client-unix")
+	addr := "unix://" + filepath.Join(t.TempDir(), "node.sock")
+	l, err := gocache.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := gocache.NewHTTPPool(addr)
+	r := gin.New()
+	pool.LoadRouters(r)
+	gocache.NewAdmin(pool).LoadRouters(r)
+	srv := &http.Server{Handler: r}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	c, err := New([]string{addr}, &Options{RefreshInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if v, err := c.Get("client-unix", "k1"); err != nil || string(v.Data) != "value of k1" {
+		t.Fatalf("expected the value over the socket, got %q, %v", v.Data, err)
+	}
+}
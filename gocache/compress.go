@@ -0,0 +1,100 @@
+package gocache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// An Algorithm names a compression algorithm. Its value is what peers
+// exchange in cachepb.Response.Encoding.
+type Algorithm string
+
+const (
+	NoCompression Algorithm = ""
+	Gzip          Algorithm = "gzip"
+	Snappy        Algorithm = "snappy"
+	Zstd          Algorithm = "zstd"
+)
+
+// Compression configures how a Group stores its values.
+type Compression struct {
+	Algorithm Algorithm `json:"algorithm"`
+	MinSize   int       `json:"min_size"` // values shorter than MinSize bytes are stored raw
+}
+
+var (
+	// EncodeAll and DecodeAll are safe for concurrent use.
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// compress returns b compressed with alg.
+func compress(alg Algorithm, b []byte) ([]byte, error) {
+	switch alg {
+	case Gzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Snappy:
+		return snappy.Encode(nil, b), nil
+	case Zstd:
+		return zstdEncoder.EncodeAll(b, nil), nil
+	}
+	return nil, fmt.Errorf("gocache: unknown compression %q", alg)
+}
+
+// decompress returns b decompressed with alg.
+func decompress(alg Algorithm, b []byte) ([]byte, error) {
+	switch alg {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case Snappy:
+		return snappy.Decode(nil, b)
+	case Zstd:
+		return zstdDecoder.DecodeAll(b, nil)
+	}
+	return nil, fmt.Errorf("gocache: unknown compression %q", alg)
+}
+
+// compress returns the view compressed according to c. Views that are
+// too small, or that would not shrink, are returned unchanged.
+func (c Compression) compress(v ByteView) ByteView {
+	if c.Algorithm == NoCompression || v.enc != NoCompression || v.Len() < c.MinSize {
+		return v
+	}
+	b, err := compress(c.Algorithm, v.bytes())
+	if err != nil || len(b) >= v.Len() {
+		return v
+	}
+	v.b, v.s, v.enc = b, "", c.Algorithm
+	return v
+}
+
+// decompress returns the view with its data decompressed.
+func (v ByteView) decompress() (ByteView, error) {
+	if v.enc == NoCompression {
+		return v, nil
+	}
+	b, err := decompress(v.enc, v.b)
+	if err != nil {
+		return ByteView{}, fmt.Errorf("decompressing %s value: %v", v.enc, err)
+	}
+	v.b, v.enc = b, NoCompression
+	return v, nil
+}
@@ -0,0 +1,44 @@
+package gocache
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCompression tests that values are stored compressed and come back raw.
+func TestCompression(t *testing.T) {
+	doc := strings.Repeat(`{"name":"Tom","score":630},`, 100)
+	for _, alg := range []Algorithm{Gzip, Snappy, Zstd} {
+		g := NewGroup("compress-"+string(alg), 2<<20, GetterFunc(
+			func(key string) ([]byte, error) {
+				return []byte(doc), nil
+			}))
+		defer g.Close()
+		g.SetCompression(Compression{Algorithm: alg, MinSize: 64})
+
+		for i := 0; i < 2; i++ {
+			if view, err := g.Get("doc"); err != nil || view.String() != doc {
+				t.Fatalf("%s: got %d bytes, %v", alg, view.Len(), err)
+			}
+		}
+
+		stored, ok := g.mainCache.get("doc")
+		if !ok || stored.enc != alg || stored.Len() >= len(doc) {
+			t.Fatalf("%s: expected value stored compressed, got %d bytes as %q", alg, stored.Len(), stored.enc)
+		}
+
+		// a peer response carries the compressed bytes and their encoding
+		view, err := viewFromResponse(responseFromView(stored)).decompress()
+		if err != nil || view.String() != doc {
+			t.Fatalf("%s: peer round trip failed: %v", alg, err)
+		}
+	}
+}
+
+// TestCompressionMinSize tests that small values are stored raw.
+func TestCompressionMinSize(t *testing.T) {
+	c := Compression{Algorithm: Zstd, MinSize: 64}
+	if v := c.compress(ByteView{b: []byte("630")}); v.enc != NoCompression {
+		t.Fatalf("value below MinSize was compressed")
+	}
+}
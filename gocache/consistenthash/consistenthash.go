@@ -58,3 +58,42 @@ func (m *Map) Get(key string) string {
 
 	return m.hashMap[m.keys[idx%len(m.keys)]]
 }
+
+// GetN gets up to n distinct nodes for the provided key, walking the ring
+// clockwise from it. The first one is the node Get returns, the others are
+// its successors.
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.keys) == 0 || n <= 0 {
+		return nil
+	}
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(m.keys) && len(nodes) < n; i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// VirtualNode is a point on the hash ring
+type VirtualNode struct {
+	Hash int    `json:"hash"`
+	Node string `json:"node"`
+}
+
+// Ring returns the virtual nodes in ring order
+func (m *Map) Ring() []VirtualNode {
+	ring := make([]VirtualNode, 0, len(m.keys))
+	for _, hash := range m.keys {
+		ring = append(ring, VirtualNode{Hash: hash, Node: m.hashMap[hash]})
+	}
+	return ring
+}
@@ -1,19 +1,29 @@
 package gocache
 
 import (
+	"errors"
 	"fmt"
+	pb "gocache/cachepb"
+	"gocache/singleflight"
 	"log"
+	"sort"
 	"sync"
-	"gocache/singleflight"
-	pb "gocache/cachepb"
+	"sync/atomic"
+	"time"
 )
 
 // A Group is a cache namespace and associated data loaded spread over
 type Group struct {
-	name      string
-	getter    Getter
-	mainCache cache
-	peers     PeerPicker
+	name        string
+	getter      MetaGetter
+	mainCache   cache
+	peers       PeerPicker
+	compression Compression
+	closed      atomic.Bool
+	stats       Stats
+	loadWait    atomic.Int64                // see SetLoadWait
+	leases      leases                      // guards fills against invalidation
+	limiter     atomic.Pointer[loadLimiter] // nil if loads are not limited
 	// use singleflight.Group to make sure that each key is only fetched once
 	loader *singleflight.Group
 }
@@ -31,12 +41,156 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// GetWithMeta implements MetaGetter interface with empty metadata.
+func (f GetterFunc) GetWithMeta(key string) ([]byte, Meta, error) {
+	b, err := f(key)
+	return b, Meta{}, err
+}
+
+// Meta describes a value returned by a loader.
+type Meta struct {
+	TTL         time.Duration // how long the value stays valid, 0 means forever
+	Version     int64         // loader-defined version of the value
+	ContentType string        // MIME type of the value, may be empty
+	Cost        int64         // loader-reported cost of producing the value
+	Flags       uint32        // opaque client flags, e.g. set over memcached
+}
+
+// MetaGetter loads data for a key together with its metadata.
+type MetaGetter interface {
+	GetWithMeta(key string) ([]byte, Meta, error)
+}
+
+// MetaGetterFunc implements MetaGetter with a function.
+type MetaGetterFunc func(key string) ([]byte, Meta, error)
+
+// GetWithMeta implements MetaGetter interface.
+func (f MetaGetterFunc) GetWithMeta(key string) ([]byte, Meta, error) {
+	return f(key)
+}
+
+// Get implements Getter interface, dropping the metadata.
+func (f MetaGetterFunc) Get(key string) ([]byte, error) {
+	b, _, err := f(key)
+	return b, err
+}
+
+// AsMetaGetter adapts a Getter to a MetaGetter. Getters that already
+// implement MetaGetter are returned as is, others report empty metadata.
+func AsMetaGetter(getter Getter) MetaGetter {
+	if mg, ok := getter.(MetaGetter); ok {
+		return mg
+	}
+	return MetaGetterFunc(func(key string) ([]byte, Meta, error) {
+		b, err := getter.Get(key)
+		return b, Meta{}, err
+	})
+}
+
+// SinkGetter loads data for a key straight into a Sink, sparing the copy
+// the cache makes of bytes returned by Getter.
+type SinkGetter interface {
+	GetSink(key string, dest Sink) (Meta, error)
+}
+
+// SinkGetterFunc implements SinkGetter with a function.
+type SinkGetterFunc func(key string, dest Sink) (Meta, error)
+
+// GetSink implements SinkGetter interface.
+func (f SinkGetterFunc) GetSink(key string, dest Sink) (Meta, error) {
+	return f(key, dest)
+}
+
+// GetWithMeta implements MetaGetter interface.
+func (f SinkGetterFunc) GetWithMeta(key string) ([]byte, Meta, error) {
+	var b []byte
+	meta, err := f(key, AllocatingByteSliceSink(&b))
+	return b, meta, err
+}
+
+// Get implements Getter interface, dropping the metadata.
+func (f SinkGetterFunc) Get(key string) ([]byte, error) {
+	b, _, err := f.GetWithMeta(key)
+	return b, err
+}
+
+// BatchGetter loads several keys at once, e.g. with a single query, more
+// cheaply than one by one. GetMany hands the keys it has to load on this
+// node to GetBatch together.
+type BatchGetter interface {
+	GetBatch(keys []string) []BatchResult
+}
+
+// BatchResult is what a BatchGetter loaded for one key.
+type BatchResult struct {
+	Value []byte
+	Meta  Meta
+	Err   error
+}
+
+// BatchGetterFunc implements BatchGetter with a function. GetBatch must
+// return a result for each key, in the order of keys.
+type BatchGetterFunc func(keys []string) []BatchResult
+
+// GetBatch implements BatchGetter interface.
+func (f BatchGetterFunc) GetBatch(keys []string) []BatchResult {
+	return f(keys)
+}
+
+// GetWithMeta implements MetaGetter interface with a batch of one.
+func (f BatchGetterFunc) GetWithMeta(key string) ([]byte, Meta, error) {
+	res := f([]string{key})
+	if len(res) == 0 {
+		return nil, Meta{}, fmt.Errorf("gocache: no value loaded for %q", key)
+	}
+	return res[0].Value, res[0].Meta, res[0].Err
+}
+
+// Get implements Getter interface, dropping the metadata.
+func (f BatchGetterFunc) Get(key string) ([]byte, error) {
+	b, _, err := f.GetWithMeta(key)
+	return b, err
+}
+
+// nowFunc returns the current time, replaced in tests.
+var nowFunc = time.Now
+
 var (
 	mu     sync.RWMutex
 	groups = make(map[string]*Group)
 )
 
-// NewGroup creates a new instance of Group.
+// ErrGroupClosed is returned by operations on a Group after Close.
+var ErrGroupClosed = errors.New("gocache: group closed")
+
+// ErrNoSuchGroup is answered by a node asked for a group it does not have.
+var ErrNoSuchGroup = errors.New("gocache: no such group")
+
+// ErrLoading is matched by a LoadingError with errors.Is.
+var ErrLoading = errors.New("gocache: key is loading")
+
+// LoadingError reports that the node loading a key for the cluster has not
+// finished yet. The caller should ask again after RetryAfter rather than
+// load the key itself.
+type LoadingError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoadingError) Error() string {
+	return fmt.Sprintf("gocache: key is loading, retry after %v", e.RetryAfter)
+}
+
+// Is makes errors.Is(err, ErrLoading) hold for a LoadingError.
+func (e *LoadingError) Is(target error) bool {
+	return target == ErrLoading
+}
+
+// NewGroup creates a new instance of Group. If getter also implements
+// MetaGetter, the metadata it returns is kept with the cached values, and
+// if it implements SinkGetter it is handed a Sink to write into.
+//
+// If a group named name already exists, it is returned unchanged and
+// cacheBytes and getter are ignored.
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 	if getter == nil {
 		panic("nil Getter")
@@ -45,9 +199,14 @@ func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 	mu.Lock()
 	defer mu.Unlock()
 
+	if g, ok := groups[name]; ok {
+		log.Printf("[GoCache] group %s already exists", name)
+		return g
+	}
+
 	g := &Group{
 		name:   name,
-		getter: getter,
+		getter: AsMetaGetter(getter),
 		mainCache: cache{
 			cacheBytes: cacheBytes,
 		},
@@ -68,18 +227,362 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// DeleteGroup closes and removes the named group, reporting whether it
+// existed.
+func DeleteGroup(name string) bool {
+	g := GetGroup(name)
+	if g == nil {
+		return false
+	}
+	g.Close()
+	return true
+}
+
+// ListGroups returns the names of all groups, sorted.
+func ListGroups() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name returns the name of the group.
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Close unregisters the group, so that the peer pool no longer serves it
+// to other nodes, and releases its cache. Get on a closed group returns
+// ErrGroupClosed. Close is safe to call more than once.
+func (g *Group) Close() error {
+	if !g.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	mu.Lock()
+	if groups[g.name] == g {
+		delete(groups, g.name)
+	}
+	mu.Unlock()
+
+	g.mainCache.close()
+	return nil
+}
+
 // Get look up a key's value from the cache.
 func (g *Group) Get(key string) (ByteView, error) {
+	view, err := g.get(key)
+	if err != nil {
+		return ByteView{}, err
+	}
+	return view.decompress()
+}
+
+// get looks up a key's value in the form it is stored, which may be
+// compressed.
+func (g *Group) get(key string) (ByteView, error) {
+	return g.getFor(key, loadOpts{})
+}
+
+// loadOpts tunes a load made on behalf of a peer.
+type loadOpts struct {
+	fallback bool          // the peer could not reach the owner, load here
+	wait     time.Duration // give up with a LoadingError after this long, 0 waits
+}
+
+// getFor is get on behalf of a peer.
+func (g *Group) getFor(key string, opts loadOpts) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
+	if g.closed.Load() {
+		return ByteView{}, ErrGroupClosed
+	}
 
+	g.stats.Gets.Add(1)
 	if v, ok := g.mainCache.get(key); ok {
 		log.Println("[GoCache] hit")
+		g.stats.CacheHits.Add(1)
 		return v, nil
 	}
 
-	return g.load(key)
+	return g.load(key, opts)
+}
+
+// SetLoadWait bounds how long a peer asking this node for a key waits for
+// the key to load. After d the peer is told to retry later instead, and
+// gets a LoadingError rather than loading the key itself. Zero, the
+// default, makes peers wait for the load to finish.
+func (g *Group) SetLoadWait(d time.Duration) {
+	g.loadWait.Store(int64(d))
+}
+
+// LoadWait returns the duration set by SetLoadWait.
+func (g *Group) LoadWait() time.Duration {
+	return time.Duration(g.loadWait.Load())
+}
+
+// Lookup returns a key's value if it is in the local cache, without
+// loading it, asking peers or updating its recency.
+func (g *Group) Lookup(key string) (ByteView, bool) {
+	view, ok := g.mainCache.peek(key)
+	if !ok {
+		return ByteView{}, false
+	}
+	view, err := view.decompress()
+	return view, err == nil
+}
+
+// Set stores value under key in the local cache as if the getter had
+// returned it with meta. Peers are not told about the value.
+func (g *Group) Set(key string, value []byte, meta Meta) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if g.closed.Load() {
+		return ErrGroupClosed
+	}
+	// the new lease revokes those of loads in flight, which would
+	// otherwise overwrite value with what they read before
+	token := g.leases.acquire(key)
+	g.populateCache(key, g.prepareView(ByteView{b: cloneBytes(value)}, meta), token)
+	return nil
+}
+
+// Touch changes the time to live of a key in the local cache to ttl,
+// reporting whether the key was cached. A ttl of 0 keeps the value
+// forever. Peers keep their own copies.
+func (g *Group) Touch(key string, ttl time.Duration) bool {
+	var expire time.Time
+	if ttl > 0 {
+		expire = nowFunc().Add(ttl)
+	}
+	return g.mainCache.touch(key, ttl, expire)
+}
+
+// Remove removes a key from the local cache, reporting whether it was
+// cached. Peers keep their own copies. Gets arriving afterwards start a
+// fresh load rather than joining one already in flight, and loads already
+// in flight no longer fill the cache.
+func (g *Group) Remove(key string) bool {
+	g.loader.Forget(key)
+	g.leases.revoke(key)
+	return g.mainCache.remove(key)
+}
+
+// Scan returns up to limit locally cached keys starting with prefix that
+// sort after cursor, in ascending order, and the cursor of the next page,
+// empty once the scan is complete. The cache is only locked while the
+// matching keys are copied. Expired keys not yet evicted may be listed.
+func (g *Group) Scan(prefix, cursor string, limit int) (keys []string, next string) {
+	return g.mainCache.scan(prefix, cursor, limit)
+}
+
+// maxScanLimit caps the page a peer or an operator may scan at once.
+const maxScanLimit = 1000
+
+// errScanLimit is answered to a scan for a page of no keys or a bad size.
+var errScanLimit = errors.New("gocache: scan limit must be a positive integer")
+
+// scanLimit checks the page size of a scan served to a peer or an
+// operator, where unlike for Group.Scan 0 does not mean all keys, and caps
+// it at maxScanLimit.
+func scanLimit(limit int) (int, error) {
+	if limit <= 0 {
+		return 0, errScanLimit
+	}
+	return min(limit, maxScanLimit), nil
+}
+
+// Purge removes all keys from the local cache. Loads in flight no longer
+// fill the cache.
+func (g *Group) Purge() {
+	g.leases.revokeAll()
+	g.mainCache.purge()
+}
+
+// SetCacheBytes changes the memory limit of the group's cache, evicting
+// entries right away when it shrinks, so a group can be grown or shrunk
+// while it serves.
+func (g *Group) SetCacheBytes(cacheBytes int64) {
+	g.mainCache.resize(cacheBytes)
+}
+
+// maxValueBytes caps the values written through the admin API and the
+// protocol frontends to a group without a memory limit.
+const maxValueBytes = 64 << 20
+
+// valueLimit returns the size of the largest value worth writing to the
+// group: its memory limit, as a larger one would be evicted right away, or
+// maxValueBytes without one.
+func (g *Group) valueLimit() int64 {
+	if _, cacheBytes := g.mainCache.stats(); cacheBytes > 0 {
+		return cacheBytes
+	}
+	return maxValueBytes
+}
+
+// SetEvictionPolicy selects how the group's cache evicts entries. It drops
+// the cached entries and should be called before the group starts serving.
+func (g *Group) SetEvictionPolicy(policy EvictionPolicy) {
+	g.mainCache.setPolicy(policy)
+}
+
+// SetCompression makes the group store values compressed as described by
+// c. It only affects values loaded afterwards and should be called before
+// the group starts serving.
+func (g *Group) SetCompression(c Compression) {
+	g.compression = c
+}
+
+// Compression returns the group's compression setting.
+func (g *Group) Compression() Compression {
+	return g.compression
+}
+
+// GetInto looks up a key's value like Get and writes it into dest.
+// Cached values reach dest without an intermediate copy when the Sink
+// allows it.
+func (g *Group) GetInto(key string, dest Sink) error {
+	view, err := g.Get(key)
+	if err != nil {
+		return err
+	}
+	return setSinkView(dest, view)
+}
+
+// maxGetManyGets caps the keys GetMany gets one at a time concurrently.
+const maxGetManyGets = 16
+
+// GetMany looks up several keys, loading the ones not cached
+// concurrently, up to maxGetManyGets at once. errs[i] is the error for
+// keys[i], nil if views[i] holds its value. If the getter is a
+// BatchGetter, the keys this node loads itself are loaded with one call
+// to GetBatch.
+func (g *Group) GetMany(keys []string) (views []ByteView, errs []error) {
+	views = make([]ByteView, len(keys))
+	errs = make([]error, len(keys))
+
+	var (
+		batch []int // indexes of the keys to load in a batch
+		each  []int // indexes of the keys to get one at a time
+	)
+	bg, isBatch := g.getter.(BatchGetter)
+	seen := make(map[string]bool)
+	for i, key := range keys {
+		if isBatch && key != "" && !seen[key] && g.ownsKey(key) {
+			seen[key] = true
+			batch = append(batch, i)
+		} else {
+			each = append(each, i)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		slots := make(chan struct{}, maxGetManyGets)
+		for _, i := range each {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(i int) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				views[i], errs[i] = g.Get(keys[i])
+			}(i)
+		}
+	}()
+
+	if len(batch) > 0 {
+		batchKeys := make([]string, len(batch))
+		for j, i := range batch {
+			batchKeys[j] = keys[i]
+		}
+		batchViews, batchErrs := g.getBatch(bg, batchKeys)
+		for j, i := range batch {
+			views[i], errs[i] = batchViews[j], batchErrs[j]
+		}
+	}
+	wg.Wait()
+	return views, errs
+}
+
+// ownsKey reports whether this node loads key itself rather than asking a
+// peer for it.
+func (g *Group) ownsKey(key string) bool {
+	if g.peers == nil {
+		return true
+	}
+	_, ok := g.peers.PickPeer(key)
+	return !ok
+}
+
+// getBatch is Get for distinct keys this node owns, loading those not
+// cached with one call to bg. Keys already loading join their loads.
+func (g *Group) getBatch(bg BatchGetter, keys []string) ([]ByteView, []error) {
+	views := make([]ByteView, len(keys))
+	errs := make([]error, len(keys))
+	if g.closed.Load() {
+		for i := range errs {
+			errs[i] = ErrGroupClosed
+		}
+		return views, errs
+	}
+
+	var (
+		missing []string
+		idx     []int
+	)
+	for i, key := range keys {
+		g.stats.Gets.Add(1)
+		if v, ok := g.mainCache.get(key); ok {
+			log.Println("[GoCache] hit")
+			g.stats.CacheHits.Add(1)
+			views[i] = v
+			continue
+		}
+		missing = append(missing, key)
+		idx = append(idx, i)
+	}
+
+	if len(missing) > 0 {
+		g.stats.Loads.Add(int64(len(missing)))
+		results := g.loader.DoMany(missing, func(keys []string) (results []singleflight.Result) {
+			// a load wait may join a key of the batch, so a panic becomes
+			// the error of every key, see load
+			defer func() {
+				if r := recover(); r != nil {
+					err := fmt.Errorf("gocache: loading a batch panicked: %v", r)
+					results = make([]singleflight.Result, len(keys))
+					for i := range results {
+						results[i].Err = err
+					}
+				}
+			}()
+			g.stats.LoadsDeduped.Add(int64(len(keys)))
+			return g.loadBatch(bg, keys)
+		})
+		for j, res := range results {
+			if errs[idx[j]] = res.Err; res.Err == nil {
+				views[idx[j]] = res.Val.(ByteView)
+			}
+		}
+	}
+
+	for i := range views {
+		if errs[i] == nil {
+			views[i], errs[i] = views[i].decompress()
+		}
+	}
+	return views, errs
 }
 
 func (g *Group) RegisterPeers(peers PeerPicker) {
@@ -90,19 +593,52 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 	g.peers = peers
 }
 
-func (g *Group) load(key string) (value ByteView, err error) {
-	view, err := g.loader.Do(key, func() (interface{}, error) {
-		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
-					return value, nil
+func (g *Group) load(key string, opts loadOpts) (value ByteView, err error) {
+	g.stats.Loads.Add(1)
+	fn := func() (interface{}, error) {
+		g.stats.LoadsDeduped.Add(1)
+		if !opts.fallback {
+			value, ok, err := g.loadFromPeers(key)
+			if ok {
+				if err != nil {
+					if !retryLater(err) {
+						g.stats.PeerErrors.Add(1)
+					}
+					return nil, err
 				}
-				log.Println("[GoCache] Failed to get from peer", err)
+				g.stats.PeerLoads.Add(1)
+				return value, nil
 			}
 		}
-		// if no peers or peer failed, get locally
-		return g.getLocally(key)
-	})
+		// if no peers or peers failed, get locally
+		value, err := g.getLocally(key)
+		if err != nil {
+			if !errors.Is(err, ErrOverloaded) {
+				g.stats.LocalLoadErrs.Add(1)
+			}
+			return nil, err
+		}
+		g.stats.LocalLoads.Add(1)
+		return value, nil
+	}
+
+	// a DoChan waiter may join a load led by Do, and the loader cannot hand
+	// a panic to it, so every load turns a panic into an error
+	safe := func() (v interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("gocache: loading %q panicked: %v", key, r)
+			}
+		}()
+		return fn()
+	}
+
+	var view interface{}
+	if opts.wait <= 0 {
+		view, err, _ = g.loader.Do(key, safe)
+	} else {
+		view, err = g.loadWithin(key, opts.wait, safe)
+	}
 
 	if err == nil {
 		return view.(ByteView), nil
@@ -110,38 +646,256 @@ func (g *Group) load(key string) (value ByteView, err error) {
 	return
 }
 
-// getFromPeer gets the value from peer.
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+// loadWithin runs fn through the loader, returning a LoadingError if it
+// has not finished after wait. The load goes on in the background and
+// fills the cache once done. fn must not panic, see load.
+func (g *Group) loadWithin(key string, wait time.Duration, fn func() (interface{}, error)) (interface{}, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case res := <-g.loader.DoChan(key, fn):
+		return res.Val, res.Err
+	case <-timer.C:
+		return nil, &LoadingError{RetryAfter: wait}
+	}
+}
+
+// loadFromPeers asks the owner of key for it, or if the owner cannot be
+// reached, the peer standing in for it, so that the key is loaded by one
+// node only. ok is false if the key should be loaded locally, because this
+// node owns it or stands in for its owner, or as a last resort when no
+// peer answered.
+func (g *Group) loadFromPeers(key string) (value ByteView, ok bool, err error) {
+	if g.peers == nil {
+		return ByteView{}, false, nil
+	}
+	peer, ok := g.peers.PickPeer(key)
+	if !ok {
+		return ByteView{}, false, nil
+	}
+	if value, err = g.getFromPeer(peer, key, false); peerAnswered(err) {
+		return value, true, err
+	}
+	g.stats.PeerErrors.Add(1)
+	log.Println("[GoCache] Failed to get from peer", err)
+
+	fp, ok := g.peers.(FallbackPicker)
+	if !ok {
+		return ByteView{}, false, nil
+	}
+	if peer, ok = fp.PickFallback(key); !ok {
+		return ByteView{}, false, nil
+	}
+	if value, err = g.getFromPeer(peer, key, true); peerAnswered(err) {
+		return value, true, err
+	}
+	g.stats.PeerErrors.Add(1)
+	log.Println("[GoCache] Failed to get from fallback peer", err)
+	return ByteView{}, false, nil
+}
+
+// retryLater reports whether a peer answered err because it is busy with
+// the key or shedding load, in which case loading the key elsewhere would
+// defeat the purpose.
+func retryLater(err error) bool {
+	_, ok := RetryAfter(err)
+	return ok
+}
+
+// peerAnswered reports whether err came from a peer that was reached and
+// answered, e.g. because its getter failed. Only a peer that could not be
+// reached is worth standing in for.
+func peerAnswered(err error) bool {
+	var se *serverError
+	return err == nil || retryLater(err) || errors.As(err, &se)
+}
+
+// getFromPeer gets the value from peer. fallback asks the peer to load
+// the key in place of its unreachable owner.
+func (g *Group) getFromPeer(peer PeerGetter, key string, fallback bool) (ByteView, error) {
+	view, err := fetchFromPeer(peer, g.name, key, fallback)
+	if errors.Is(err, ErrLoading) {
+		g.stats.PeerLoading.Add(1)
+	}
+	// no need to populate cache here
+	return view, err
+}
+
+// fetchFromPeer gets the value of key in group from peer, in the form it
+// is stored.
+func fetchFromPeer(peer PeerGetter, group, key string, fallback bool) (ByteView, error) {
 	req := &pb.Request{
-		Group: g.name,
-		Key:   key,
+		Group:            group,
+		Key:              key,
+		AcceptCompressed: true,
+		Fallback:         fallback,
 	}
 	res := &pb.Response{}
 	err := peer.Get(req, res)
 	if err != nil {
 		return ByteView{}, err
 	}
-	// no need to populate cache here
+	if ms := res.GetRetryAfter(); ms > 0 {
+		return ByteView{}, &LoadingError{RetryAfter: time.Duration(ms) * time.Millisecond}
+	}
 
-	return ByteView{b: res.Value}, nil
+	return viewFromResponse(res), nil
 }
 
 // getLocally gets the value from local.
 func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
-
+	var (
+		value ByteView
+		meta  Meta
+		err   error
+	)
+	release, err := g.admitLoad()
 	if err != nil {
 		return ByteView{}, err
 	}
+	defer release()
+
+	token := g.leases.acquire(key)
+	if sg, ok := g.getter.(SinkGetter); ok {
+		// the sink takes the only copy of the value
+		meta, err = sg.GetSink(key, ByteViewSink(&value))
+	} else {
+		var bytes []byte
+		bytes, meta, err = g.getter.GetWithMeta(key)
+		value = ByteView{b: cloneBytes(bytes)}
+	}
 
-	value := ByteView{
-		b: cloneBytes(bytes),
+	if err != nil {
+		g.leases.release(key, token)
+		return ByteView{}, err
 	}
 
-	g.populateCache(key, value)
+	value = g.prepareView(value, meta)
+	g.populateCache(key, value, token)
 	return value, nil
 }
 
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+// loadBatch loads keys locally with one call to bg, which counts as a
+// single load against the load limits.
+func (g *Group) loadBatch(bg BatchGetter, keys []string) []singleflight.Result {
+	results := make([]singleflight.Result, len(keys))
+	release, err := g.admitLoad()
+	if err != nil {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results
+	}
+	defer release()
+
+	tokens := make([]uint64, len(keys))
+	for i, key := range keys {
+		tokens[i] = g.leases.acquire(key)
+	}
+	loaded := bg.GetBatch(keys)
+
+	for i, key := range keys {
+		res := BatchResult{Err: fmt.Errorf("gocache: no value loaded for %q", key)}
+		if i < len(loaded) {
+			res = loaded[i]
+		}
+		if res.Err != nil {
+			g.leases.release(key, tokens[i])
+			g.stats.LocalLoadErrs.Add(1)
+			results[i].Err = res.Err
+			continue
+		}
+		value := g.prepareView(ByteView{b: cloneBytes(res.Value)}, res.Meta)
+		g.populateCache(key, value, tokens[i])
+		g.stats.LocalLoads.Add(1)
+		results[i].Val = value
+	}
+	return results
+}
+
+// admitLoad waits for the load limits to admit a local load, counting it
+// in the stats. release must be called once the load is done.
+func (g *Group) admitLoad() (release func(), err error) {
+	l := g.limiter.Load()
+	if l != nil {
+		waited, err := l.acquire()
+		if waited {
+			g.stats.LoadsQueued.Add(1)
+		}
+		if err != nil {
+			g.stats.LoadsShed.Add(1)
+			return nil, err
+		}
+	}
+	g.stats.LoadsInFlight.Add(1)
+	return func() {
+		g.stats.LoadsInFlight.Add(-1)
+		if l != nil {
+			l.release()
+		}
+	}, nil
+}
+
+// prepareView attaches meta to a freshly loaded value and brings it into
+// the form it is stored in.
+func (g *Group) prepareView(value ByteView, meta Meta) ByteView {
+	value.meta = meta
+	if meta.TTL > 0 {
+		value.expire = nowFunc().Add(meta.TTL)
+	}
+	return g.compression.compress(value)
+}
+
+// populateCache adds value to the cache if the lease token on key has not
+// been revoked since the value was read.
+func (g *Group) populateCache(key string, value ByteView, token uint64) {
+	if g.closed.Load() {
+		// a load that finished after Close must not revive the cache,
+		// the cache itself drops those that race with it
+		g.leases.release(key, token)
+		return
+	}
+	if !g.leases.fill(key, token, func() { g.mainCache.add(key, value) }) {
+		// the key was invalidated while it loaded, the value may be stale
+		g.stats.StaleFills.Add(1)
+		return
+	}
+	enforceMemoryBudget()
+}
+
+// viewFromResponse builds a ByteView from a peer response.
+func viewFromResponse(res *pb.Response) ByteView {
+	view := ByteView{
+		b:   res.GetValue(),
+		enc: Algorithm(res.GetEncoding()),
+		meta: Meta{
+			Version:     res.GetVersion(),
+			ContentType: res.GetContentType(),
+			Cost:        res.GetCost(),
+			Flags:       res.GetFlags(),
+		},
+	}
+	if ttl := time.Duration(res.GetTtl()) * time.Millisecond; ttl > 0 {
+		view.meta.TTL = ttl
+		view.expire = nowFunc().Add(ttl)
+	}
+	return view
+}
+
+// responseFromView builds a peer response from a ByteView.
+func responseFromView(view ByteView) *pb.Response {
+	res := &pb.Response{
+		Value:       view.bytes(),
+		Version:     view.meta.Version,
+		ContentType: view.meta.ContentType,
+		Cost:        view.meta.Cost,
+		Flags:       view.meta.Flags,
+		Encoding:    string(view.enc),
+	}
+	if ttl := view.TTL(); ttl > 0 {
+		// round up so that a short remaining ttl never reads as "no expiry"
+		res.Ttl = int64((ttl + time.Millisecond - 1) / time.Millisecond)
+	}
+	return res
 }
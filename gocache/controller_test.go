@@ -1,10 +1,19 @@
 package gocache
 
 import (
+	"errors"
 	"fmt"
+	pb "gocache/cachepb"
 	"log"
+	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/proto"
 )
 
 // simulate a slow database
@@ -44,6 +53,7 @@ func TestGet(t *testing.T) {
 			}
 			return nil, fmt.Errorf("%s not exist", key)
 		}))
+	defer g.Close()
 
 	// load all keys in DB
 	for k, v := range db {
@@ -64,8 +74,9 @@ func TestGet(t *testing.T) {
 // TestGetGroup tests that a value can be gotten from cache.
 func TestGetGroup(t *testing.T) {
 	groupName := "scores"
-	NewGroup(groupName, 2<<10, GetterFunc(
+	g := NewGroup(groupName, 2<<10, GetterFunc(
 		func(key string) (bytes []byte, err error) { return }))
+	defer g.Close()
 	// get group
 	if group := GetGroup(groupName); group == nil || group.name != groupName {
 		t.Fatalf("group %s not exist", groupName)
@@ -75,3 +86,394 @@ func TestGetGroup(t *testing.T) {
 		t.Fatalf("group %s should not exist", groupName)
 	}
 }
+
+// TestGetWithMeta tests that loader metadata is cached and that values
+// expire after their TTL.
+func TestGetWithMeta(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	loads := 0
+	g := NewGroup("meta", 2<<10, MetaGetterFunc(
+		func(key string) ([]byte, Meta, error) {
+			loads++
+			return []byte(key), Meta{
+				TTL:         time.Minute,
+				Version:     int64(loads),
+				ContentType: "text/plain",
+			}, nil
+		}))
+	defer g.Close()
+
+	view, err := g.Get("Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := view.Meta(); m.Version != 1 || m.ContentType != "text/plain" || m.TTL != time.Minute {
+		t.Fatalf("unexpected meta %+v", m)
+	}
+	if !view.Expire().Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected expire %v, got %v", now.Add(time.Minute), view.Expire())
+	}
+
+	now = now.Add(30 * time.Second)
+	if view, _ = g.Get("Tom"); loads != 1 || view.TTL() != 30*time.Second {
+		t.Fatalf("expected cache hit with 30s left, got %d loads and ttl %v", loads, view.TTL())
+	}
+
+	now = now.Add(30 * time.Second)
+	if view, _ = g.Get("Tom"); loads != 2 || view.Meta().Version != 2 {
+		t.Fatalf("expected expired entry to be reloaded, got %d loads", loads)
+	}
+}
+
+// TestResponseMeta tests that metadata survives the trip to a peer.
+func TestResponseMeta(t *testing.T) {
+	view := ByteView{
+		b:      []byte("630"),
+		meta:   Meta{TTL: time.Minute, Version: 7, ContentType: "text/plain", Cost: 3, Flags: 5},
+		expire: nowFunc().Add(time.Minute),
+	}
+	got := viewFromResponse(responseFromView(view))
+	if got.String() != "630" || got.meta.Version != 7 || got.meta.ContentType != "text/plain" || got.meta.Cost != 3 || got.meta.Flags != 5 {
+		t.Fatalf("metadata lost: %+v", got.meta)
+	}
+	if ttl := got.TTL(); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+}
+
+// TestGroupLifecycle tests duplicate registration, listing and removal.
+func TestGroupLifecycle(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	})
+	g := NewGroup("lifecycle", 2<<10, getter)
+	if dup := NewGroup("lifecycle", 4<<10, getter); dup != g {
+		t.Fatalf("NewGroup with a duplicate name should return the existing group")
+	}
+
+	found := false
+	for _, name := range ListGroups() {
+		found = found || name == "lifecycle"
+	}
+	if !found {
+		t.Fatalf("group lifecycle not listed")
+	}
+
+	g.Get("Tom")
+	if !DeleteGroup("lifecycle") || GetGroup("lifecycle") != nil {
+		t.Fatalf("group lifecycle not deleted")
+	}
+	if DeleteGroup("lifecycle") {
+		t.Fatalf("deleting a missing group should report false")
+	}
+	if _, err := g.Get("Tom"); err != ErrGroupClosed {
+		t.Fatalf("expected ErrGroupClosed, got %v", err)
+	}
+	if g.mainCache.bytes() != 0 {
+		t.Fatalf("closed group still holds %d bytes", g.mainCache.bytes())
+	}
+	// a load that raced with Close fills the cache after it
+	g.mainCache.add("Tom", ByteView{b: []byte("630")})
+	if g.mainCache.bytes() != 0 {
+		t.Fatalf("closed group revived by a late fill, holds %d bytes", g.mainCache.bytes())
+	}
+	if err := g.Close(); err != nil {
+		t.Fatalf("second Close failed: %v", err)
+	}
+}
+
+// TestSetCacheBytes tests that a group can be shrunk and grown live.
+func TestSetCacheBytes(t *testing.T) {
+	g := NewGroup("resize", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer g.Close()
+	keys := []string{"k1", "k2", "k3", "k4", "k5"}
+	for _, k := range keys {
+		g.Get(k)
+	}
+
+	one := g.mainCache.bytes() / int64(len(keys))
+	g.SetCacheBytes(2 * one)
+	if stats := g.Stats(); stats.Items != 2 || stats.CacheBytes != 2*one {
+		t.Fatalf("expected 2 items after shrinking, got %+v", stats)
+	}
+
+	g.SetCacheBytes(0)
+	for _, k := range keys {
+		g.Get(k)
+	}
+	if stats := g.Stats(); stats.Items != len(keys) {
+		t.Fatalf("expected %d items after growing, got %d", len(keys), stats.Items)
+	}
+}
+
+// fakePeer answers Get with a fixed response or error.
+type fakePeer struct {
+	res  *pb.Response
+	err  error
+	reqs []*pb.Request
+}
+
+func (p *fakePeer) Get(in *pb.Request, out *pb.Response) error {
+	p.reqs = append(p.reqs, in)
+	if p.err != nil {
+		return p.err
+	}
+	proto.Merge(out, p.res)
+	return nil
+}
+
+// fakePicker picks owner for every key and fallback when it is unreachable.
+type fakePicker struct {
+	owner, fallback *fakePeer
+}
+
+func (p *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	return p.owner, p.owner != nil
+}
+
+func (p *fakePicker) PickFallback(key string) (PeerGetter, bool) {
+	return p.fallback, p.fallback != nil
+}
+
+// TestLoadFallback tests that an unreachable owner is replaced by the
+// fallback peer, and that the key is only loaded locally when this node is
+// the fallback.
+func TestLoadFallback(t *testing.T) {
+	var loads atomic.Int32
+	g := NewGroup("fallback", 0, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("local"), nil
+	}))
+	defer g.Close()
+
+	picker := &fakePicker{
+		owner:    &fakePeer{err: errors.New("connection refused")},
+		fallback: &fakePeer{res: &pb.Response{Value: []byte("fallback")}},
+	}
+	g.RegisterPeers(picker)
+
+	if view, err := g.Get("k1"); err != nil || view.String() != "fallback" {
+		t.Fatalf("expected the fallback peer's value, got %q, %v", view.String(), err)
+	}
+	if req := picker.fallback.reqs[0]; !req.GetFallback() || req.GetKey() != "k1" {
+		t.Fatalf("expected a fallback request for k1, got %v", req)
+	}
+	if loads.Load() != 0 {
+		t.Fatalf("expected no local load, got %d", loads.Load())
+	}
+
+	picker.fallback = nil
+	if view, err := g.Get("k2"); err != nil || view.String() != "local" || loads.Load() != 1 {
+		t.Fatalf("expected a local load standing in for the owner, got %q, %v", view.String(), err)
+	}
+}
+
+// TestPeerLoading tests that a peer still loading the key makes Get
+// return a LoadingError instead of loading the key locally.
+func TestPeerLoading(t *testing.T) {
+	var loads atomic.Int32
+	g := NewGroup("peer-loading", 0, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return []byte("local"), nil
+	}))
+	defer g.Close()
+	g.RegisterPeers(&fakePicker{owner: &fakePeer{res: &pb.Response{RetryAfter: 50}}})
+
+	_, err := g.Get("k1")
+	var loading *LoadingError
+	if !errors.Is(err, ErrLoading) || !errors.As(err, &loading) || loading.RetryAfter != 50*time.Millisecond {
+		t.Fatalf("expected a LoadingError retrying after 50ms, got %v", err)
+	}
+	if loads.Load() != 0 || g.Stats().PeerLoading != 1 {
+		t.Fatalf("expected no local load and one loading reply, got %d loads, %+v", loads.Load(), g.Stats())
+	}
+}
+
+// TestLoadWait tests that the owner tells peers to retry while a slow load
+// is in flight, and serves the value once it is done.
+func TestLoadWait(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var loads atomic.Int32
+	release := make(chan struct{})
+	g := NewGroup("load-wait", 0, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		<-release
+		return []byte("slow"), nil
+	}))
+	defer g.Close()
+	g.SetLoadWait(10 * time.Millisecond)
+
+	r := gin.New()
+	NewHTTPPool("").LoadRouters(r)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	for i := 0; i < 2; i++ {
+		res := &pb.Response{}
+		if err := peer.Get(&pb.Request{Group: "load-wait", Key: "k1"}, res); err != nil {
+			t.Fatal(err)
+		}
+		if res.GetRetryAfter() != 10 || res.GetValue() != nil {
+			t.Fatalf("expected to retry after 10ms, got %v", res)
+		}
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		res := &pb.Response{}
+		if err := peer.Get(&pb.Request{Group: "load-wait", Key: "k1"}, res); err != nil {
+			t.Fatal(err)
+		}
+		if string(res.GetValue()) == "slow" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("load did not finish, last response %v", res)
+		}
+	}
+	if loads.Load() != 1 {
+		t.Fatalf("expected a single load, got %d", loads.Load())
+	}
+}
+
+// TestLoadPanic tests that a panicking Getter fails the load it leads with
+// Get and the waiters that joined it with a load wait, rather than
+// crashing the process.
+func TestLoadPanic(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	g := NewGroup("load-panic", 0, GetterFunc(func(key string) ([]byte, error) {
+		entered <- struct{}{}
+		<-release
+		panic("getter failed")
+	}))
+	defer g.Close()
+
+	led := make(chan error, 1)
+	go func() {
+		_, err := g.Get("k1")
+		led <- err
+	}()
+	<-entered
+	waited := make(chan error, 1)
+	go func() {
+		_, err := g.load("k1", loadOpts{wait: time.Second})
+		waited <- err
+	}()
+	// let the waiter join the load before it panics
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	for _, errs := range []chan error{led, waited} {
+		if err := <-errs; err == nil || !strings.Contains(err.Error(), "getter failed") {
+			t.Fatalf("expected the panic as an error, got %v", err)
+		}
+	}
+}
+
+// TestGetManyBatchPanic tests that a panicking BatchGetter fails the keys
+// of its batch, also for the load waits that joined it, rather than
+// crashing the process.
+func TestGetManyBatchPanic(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	g := NewGroup("batch-panic", 0, BatchGetterFunc(func(keys []string) []BatchResult {
+		entered <- struct{}{}
+		<-release
+		panic("batch failed")
+	}))
+	defer g.Close()
+
+	led := make(chan error, 1)
+	go func() {
+		_, errs := g.GetMany([]string{"k1", "k2"})
+		led <- errs[0]
+	}()
+	<-entered
+	waited := make(chan error, 1)
+	go func() {
+		_, err := g.load("k1", loadOpts{wait: time.Second})
+		waited <- err
+	}()
+	// let the waiter join the batch before it panics
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	for _, errs := range []chan error{led, waited} {
+		if err := <-errs; err == nil || !strings.Contains(err.Error(), "batch failed") {
+			t.Fatalf("expected the panic as an error, got %v", err)
+		}
+	}
+}
+
+// prefixPicker picks peer for keys starting with prefix, and this node for
+// the others.
+type prefixPicker struct {
+	prefix string
+	peer   *fakePeer
+}
+
+func (p *prefixPicker) PickPeer(key string) (PeerGetter, bool) {
+	return p.peer, strings.HasPrefix(key, p.prefix)
+}
+
+// TestGetManyBatch tests that GetMany loads the keys this node owns with a
+// single call to a BatchGetter, and asks peers for the others.
+func TestGetManyBatch(t *testing.T) {
+	var batches [][]string
+	g := NewGroup("batch", 0, BatchGetterFunc(func(keys []string) []BatchResult {
+		batches = append(batches, keys)
+		res := make([]BatchResult, len(keys))
+		for i, key := range keys {
+			if key == "bad" {
+				res[i].Err = errors.New("no such key")
+			} else {
+				res[i] = BatchResult{Value: []byte("value of " + key), Meta: Meta{Version: 2}}
+			}
+		}
+		return res
+	}))
+	defer g.Close()
+	peer := &fakePeer{res: &pb.Response{Value: []byte("from peer")}}
+	g.RegisterPeers(&prefixPicker{prefix: "peer-", peer: peer})
+
+	if view, err := g.Get("k1"); err != nil || view.String() != "value of k1" {
+		t.Fatalf("expected a batch of one, got %q, %v", view.String(), err)
+	}
+	batches = nil
+
+	views, errs := g.GetMany([]string{"k1", "k2", "peer-k3", "bad", "k4"})
+	if len(batches) != 1 || strings.Join(batches[0], ",") != "k2,bad,k4" {
+		t.Fatalf("expected one batch of the missing local keys, got %v", batches)
+	}
+	if len(peer.reqs) != 1 || peer.reqs[0].GetKey() != "peer-k3" {
+		t.Fatalf("expected the peer to be asked for peer-k3, got %v", peer.reqs)
+	}
+	for i, want := range []string{"value of k1", "value of k2", "from peer", "", "value of k4"} {
+		if want == "" {
+			if errs[i] == nil {
+				t.Fatalf("expected an error for key %d", i)
+			}
+			continue
+		}
+		if errs[i] != nil || views[i].String() != want {
+			t.Fatalf("expected %q for key %d, got %q, %v", want, i, views[i].String(), errs[i])
+		}
+	}
+	if views[1].Meta().Version != 2 {
+		t.Fatalf("expected the batch's meta, got %+v", views[1].Meta())
+	}
+	if view, ok := g.Lookup("k4"); !ok || view.String() != "value of k4" {
+		t.Fatalf("expected k4 to be cached")
+	}
+
+	stats := g.Stats()
+	if stats.LocalLoads != 3 || stats.LocalLoadErrs != 1 || stats.PeerLoads != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
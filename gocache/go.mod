@@ -1,9 +1,10 @@
 module gocache
 
-go 1.21.1
+go 1.22
 
 require (
 	github.com/gin-gonic/gin v1.9.1
+	github.com/klauspost/compress v1.18.0
 	google.golang.org/protobuf v1.31.0
 )
 
@@ -1,6 +1,11 @@
 package gocache
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	pb "gocache/cachepb"
@@ -8,23 +13,43 @@ import (
 	"google.golang.org/protobuf/proto"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
 	defaultBasePath = "/_gocache/"
 	defaultReplicas = 50
+	// unixScheme starts the address of a node listening on a Unix domain
+	// socket, e.g. "unix:///run/gocache/8001.sock".
+	unixScheme = "unix://"
+	// unixHostSuffix ends the host NodeURL gives a node on a Unix domain
+	// socket, after the hex encoded path of the socket.
+	unixHostSuffix = ".unix"
 )
 
-// HTTPPool implements PeerPicker for a pool of HTTP peers.
+// HTTPPool implements PeerPicker for a pool of HTTP peers. Peers are base
+// URLs like "http://10.0.0.2:8008", or like "unix:///run/gocache.sock" for
+// nodes on the same host serving on a Unix domain socket, see Listen.
 type HTTPPool struct {
 	self        string                 // e.g. "localhost:8000"
 	basePath    string                 // e.g. "/_gocache/"
-	mu          sync.Mutex             // guards peers and httpGetters
+	mu          sync.Mutex             // guards the fields below
 	peers       *consistenthash.Map    // a map of peers
+	peerList    []string               // peers as passed to Set
 	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+	onSet       []func(peers []string)
+	tlsConfig   *tls.Config   // dials https peers with it, see SetTLSConfig
+	requireCert bool          // see SetRequirePeerCert
+	managers    []string      // see SetManagers
+	auth        Authenticator // see SetAuthenticator
 }
 
 // NewHTTPPool initializes an HTTP pool of peers.
@@ -35,69 +60,226 @@ func NewHTTPPool(self string) *HTTPPool {
 	}
 }
 
+// ServeHTTP serves the peer protocol on basePath, gets on
+// GET /_gocache/<group>/<key> and scans on GET /_gocache/<group>, the
+// health check on "/" and membership
+// updates on POST /set-peers, so the pool can be mounted on any router:
+//
+//	mux.Handle("/_gocache/", pool)
+//	mux.Handle("/set-peers", pool)
+func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/" && r.Method == http.MethodGet:
+		p.handleCheckEnabled(w, r)
+	case path == "/set-peers" && r.Method == http.MethodPost:
+		if _, ok := authenticate(w, r, p.authenticator()); !ok {
+			return
+		}
+		p.handleSetPeers(w, r)
+	case strings.HasPrefix(path, p.basePath) && r.Method == http.MethodGet:
+		if _, ok := authenticate(w, r, p.authenticator()); !ok {
+			return
+		}
+		if p.requirePeerCert() {
+			if _, ok := p.PeerIdentity(r); !ok {
+				writeText(w, http.StatusForbidden, ErrUnknownPeer.Error())
+				return
+			}
+		}
+		// a group's own path scans it, the paths below get its keys
+		group, key, ok := strings.Cut(path[len(p.basePath):], "/")
+		if !ok {
+			p.handleScan(w, r, group)
+			return
+		}
+		p.handleGetCache(w, r, group, key)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// LoadRouters mounts the pool on a gin router, see ServeHTTP.
 func (p *HTTPPool) LoadRouters(router *gin.Engine) {
-	router.GET(p.basePath+"/:groupname/:key", p.handleGetCache)
-	router.GET("/", p.handleCheckEnabled)
-	router.POST("/set-peers", p.handleSetPeers)
+	h := gin.WrapH(p)
+	router.GET(p.basePath+"*path", h)
+	router.GET("/", h)
+	router.POST("/set-peers", h)
 }
 
-func (p *HTTPPool) handleGetCache(c *gin.Context) {
-	groupname := c.Param("groupname")
-	key := c.Param("key")
+// writeText answers a plain text body.
+func writeText(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
 
-	group := GetGroup(groupname)
-	if group == nil {
-		c.String(http.StatusBadRequest, "no such group")
+// writeProto answers a proto message.
+func writeProto(w http.ResponseWriter, m proto.Message) {
+	body, err := proto.Marshal(m)
+	if err != nil {
+		writeText(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
 
-	view, err := group.Get(key)
-	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+func (p *HTTPPool) handleGetCache(w http.ResponseWriter, r *http.Request, group, key string) {
+	q := r.URL.Query()
+	res, err := serveGet(&pb.Request{
+		Group:            group,
+		Key:              key,
+		AcceptCompressed: q.Get("compressed") != "",
+		Fallback:         q.Get("fallback") != "",
+	})
+	switch {
+	case errors.Is(err, ErrNoSuchGroup):
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, ErrOverloaded):
+		// shed the request, the node is saturated with loads
+		retry, _ := RetryAfter(err)
+		w.Header().Set("Retry-After", retryAfterHeader(retry))
+		writeText(w, http.StatusServiceUnavailable, err.Error())
+		return
+	case err != nil:
+		writeText(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
-	if err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+	writeProto(w, res)
+}
+
+// serveGet answers a get from a peer, whatever the transport. It fails
+// with ErrNoSuchGroup, an OverloadedError if the load was shed, or the
+// error of the load. A key still loading after the group's LoadWait is
+// answered with RetryAfter set.
+func serveGet(in *pb.Request) (*pb.Response, error) {
+	group := GetGroup(in.GetGroup())
+	if group == nil {
+		return nil, ErrNoSuchGroup
+	}
+
+	group.stats.ServerRequests.Add(1)
+	view, err := group.getFor(in.GetKey(), loadOpts{
+		fallback: in.GetFallback(),
+		wait:     group.LoadWait(),
+	})
+	if err == nil && !in.GetAcceptCompressed() {
+		// the peer cannot decompress, send the raw value
+		view, err = view.decompress()
+	}
+
+	var loading *LoadingError
+	switch {
+	case errors.As(err, &loading):
+		// tell the peer to ask again rather than load the key itself
+		res := &pb.Response{RetryAfter: loading.RetryAfter.Milliseconds()}
+		if res.RetryAfter == 0 {
+			res.RetryAfter = 1
+		}
+		return res, nil
+	case errors.Is(err, ErrOverloaded):
+		group.stats.ServerShed.Add(1)
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+	return responseFromView(view), nil
+}
+
+// retryAfterHeader formats d as a Retry-After header, in whole seconds.
+func retryAfterHeader(d time.Duration) string {
+	secs := int64(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
+func (p *HTTPPool) handleScan(w http.ResponseWriter, r *http.Request, groupname string) {
+	group := GetGroup(groupname)
+	if group == nil {
+		writeText(w, http.StatusBadRequest, ErrNoSuchGroup.Error())
 		return
 	}
 
-	c.Data(http.StatusOK, "application/octet-stream", body)
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err == nil {
+		limit, err = scanLimit(limit)
+	}
+	if err != nil {
+		writeText(w, http.StatusBadRequest, errScanLimit.Error())
+		return
+	}
+	keys, next := group.Scan(q.Get("prefix"), q.Get("cursor"), limit)
+	writeProto(w, &pb.ScanResponse{Keys: keys, Next: next})
 }
 
-func (p *HTTPPool) handleCheckEnabled(c *gin.Context) {
-	c.String(http.StatusOK, "ok")
+func (p *HTTPPool) handleCheckEnabled(w http.ResponseWriter, r *http.Request) {
+	writeText(w, http.StatusOK, "ok")
 }
 
-func (p *HTTPPool) handleSetPeers(c *gin.Context) {
+func (p *HTTPPool) handleSetPeers(w http.ResponseWriter, r *http.Request) {
 	var peers []string
-	if err := c.ShouldBindJSON(&peers); err != nil {
-		c.String(http.StatusBadRequest, err.Error())
+	if err := json.NewDecoder(r.Body).Decode(&peers); err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if p.requirePeerCert() && !p.mayUpdatePeers(r) {
+		writeText(w, http.StatusForbidden, ErrUnknownPeer.Error())
 		return
 	}
 
 	p.Set(peers...)
-	c.String(http.StatusOK, "ok")
+	writeText(w, http.StatusOK, "ok")
 }
 
 // Set update the pool's list of peers.
 func (p *HTTPPool) Set(peers ...string) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	p.peers = consistenthash.New(defaultReplicas, nil)
 	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	p.peerList = append([]string(nil), peers...)
+	getters := make(map[string]*httpGetter, len(peers))
 	for _, peer := range peers {
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
+		// keep the connections to the peers that stay
+		if getter, ok := p.httpGetters[peer]; ok {
+			getters[peer] = getter
+		} else {
+			getters[peer] = newHTTPGetter(peer, p.basePath, p.tlsConfig, p.auth)
+		}
+	}
+	p.httpGetters = getters
+	onSet := p.onSet
+	p.mu.Unlock()
+
+	for _, f := range onSet {
+		f(append([]string(nil), peers...))
 	}
 }
 
+// OnSet registers f to be called with the new peers whenever Set changes
+// them, e.g. through /set-peers, so that a TCPPool serving as the peer
+// transport can follow the membership the pool is told about.
+func (p *HTTPPool) OnSet(f func(peers []string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onSet = append(p.onSet, f)
+}
+
 // PickPeer picks a peer according to key.
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
 
 	if peer := p.peers.Get(key); peer != "" && peer != p.self {
 		log.Printf("Pick peer %s", peer)
@@ -107,11 +289,164 @@ func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	return nil, false
 }
 
-// check that HTTPPool implements PeerPicker
-var _ PeerPicker = (*HTTPPool)(nil)
+// PickFallback picks the peer standing in for the owner of key, the next
+// node on the ring, for when the owner cannot be reached.
+func (p *HTTPPool) PickFallback(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+
+	if nodes := p.peers.GetN(key, 2); len(nodes) == 2 && nodes[1] != p.self {
+		log.Printf("Pick fallback peer %s", nodes[1])
+		return p.httpGetters[nodes[1]], true
+	}
+
+	return nil, false
+}
+
+// Self returns the pool's own address.
+func (p *HTTPPool) Self() string {
+	return p.self
+}
+
+// Peers returns the current list of peers.
+func (p *HTTPPool) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.peerList...)
+}
+
+// Ring returns the virtual nodes of the hash ring in ring order.
+func (p *HTTPPool) Ring() []consistenthash.VirtualNode {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil
+	}
+	return p.peers.Ring()
+}
+
+// Owner returns the peer that owns key, or "" if there are no peers.
+func (p *HTTPPool) Owner(key string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return ""
+	}
+	return p.peers.Get(key)
+}
+
+// scanners returns a PeerScanner for every peer but self.
+func (p *HTTPPool) scanners() map[string]PeerScanner {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	scanners := make(map[string]PeerScanner, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			scanners[peer] = getter
+		}
+	}
+	return scanners
+}
+
+// check that HTTPPool implements PeerPicker and FallbackPicker
+var (
+	_ PeerPicker     = (*HTTPPool)(nil)
+	_ FallbackPicker = (*HTTPPool)(nil)
+)
 
 type httpGetter struct {
 	baseURL string
+	client  *http.Client // nil uses http.DefaultClient
+}
+
+// NodeURL returns the URL the node at addr is reached at. That is addr
+// itself, except for a Unix domain socket address like
+// "unix:///run/gocache.sock", whose URL names the socket in its host so
+// that only a transport from NewNodeTransport can dial it.
+func NodeURL(addr string) string {
+	path, ok := strings.CutPrefix(addr, unixScheme)
+	if !ok {
+		return addr
+	}
+	return "http://" + hex.EncodeToString([]byte(path)) + unixHostSuffix
+}
+
+// NewNodeTransport returns a transport for the URLs of NodeURL, which
+// dials the Unix domain sockets they name and other hosts over TCP, and
+// dials https with tlsConfig if it is not nil.
+func NewNodeTransport(tlsConfig *tls.Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig.Clone()
+	}
+	proxy, dial := t.Proxy, t.DialContext
+	t.Proxy = func(r *http.Request) (*url.URL, error) {
+		if strings.HasSuffix(r.URL.Hostname(), unixHostSuffix) || proxy == nil {
+			return nil, nil
+		}
+		return proxy(r)
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			if enc, ok := strings.CutSuffix(host, unixHostSuffix); ok {
+				if path, err := hex.DecodeString(enc); err == nil {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", string(path))
+				}
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+	return t
+}
+
+// newHTTPGetter returns the getter of the peer at addr, which may be a
+// Unix domain socket address. Consistent hashing only sees addr, so such
+// peers are placed on the ring like any other. https peers are dialed
+// with tlsConfig if it is not nil, and requests are signed with auth if
+// it is not nil.
+func newHTTPGetter(addr, basePath string, tlsConfig *tls.Config, auth Authenticator) *httpGetter {
+	getter := &httpGetter{baseURL: NodeURL(addr) + basePath}
+	var transport http.RoundTripper
+	if strings.HasPrefix(addr, unixScheme) || (tlsConfig != nil && strings.HasPrefix(addr, "https://")) {
+		transport = NewNodeTransport(tlsConfig)
+	}
+	if auth != nil {
+		transport = NewAuthTransport(auth, transport)
+	}
+	if transport != nil {
+		getter.client = &http.Client{Transport: transport}
+	}
+	return getter
+}
+
+// Listen listens on addr, a Unix domain socket address like
+// "unix:///run/gocache.sock" or a TCP address with or without "http://"
+// or "https://", so that the HTTP servers of a node can be bound to any
+// address its peers may be given. A socket file left behind by a process that is gone
+// is replaced.
+func Listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixScheme)
+	if !ok {
+		addr = strings.TrimPrefix(addr, "http://")
+		addr = strings.TrimPrefix(addr, "https://")
+		return net.Listen("tcp", addr)
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("gocache: %s is in use", addr)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
 }
 
 func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
@@ -121,16 +456,57 @@ func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 		url.QueryEscape(in.GetGroup()),
 		url.QueryEscape(in.GetKey()),
 	)
+	q := url.Values{}
+	if in.GetAcceptCompressed() {
+		q.Set("compressed", "1")
+	}
+	if in.GetFallback() {
+		q.Set("fallback", "1")
+	}
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
 	log.Println("httpGetter url:", u)
-	res, err := http.Get(u)
+	return h.fetch(u, out)
+}
+
+// Scan implements PeerScanner interface.
+func (h *httpGetter) Scan(in *pb.ScanRequest, out *pb.ScanResponse) error {
+	q := url.Values{}
+	q.Set("prefix", in.GetPrefix())
+	q.Set("cursor", in.GetCursor())
+	q.Set("limit", strconv.Itoa(int(in.GetLimit())))
+	u := fmt.Sprintf(
+		"%v%v?%v",
+		h.baseURL,
+		url.QueryEscape(in.GetGroup()),
+		q.Encode(),
+	)
+	return h.fetch(u, out)
+}
+
+// fetch gets u and decodes the proto message in the response body into out.
+func (h *httpGetter) fetch(u string, out proto.Message) error {
+	client := h.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Get(u)
 	if err != nil {
 		return err
 	}
 
 	defer res.Body.Close()
 
+	if res.StatusCode == http.StatusServiceUnavailable {
+		retry := defaultRetryAfter
+		if secs, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			retry = time.Duration(secs) * time.Second
+		}
+		return &OverloadedError{RetryAfter: retry}
+	}
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+		return &serverError{status: res.Status}
 	}
 
 	bytes, err := io.ReadAll(res.Body)
@@ -145,5 +521,18 @@ func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 	return nil
 }
 
-// check that httpGetter implements PeerGetter
-var _ PeerGetter = (*httpGetter)(nil)
+// serverError is returned by an httpGetter whose peer answered with an
+// error, as opposed to one that could not be reached.
+type serverError struct {
+	status string
+}
+
+func (e *serverError) Error() string {
+	return "server returned: " + e.status
+}
+
+// check that httpGetter implements PeerGetter and PeerScanner
+var (
+	_ PeerGetter  = (*httpGetter)(nil)
+	_ PeerScanner = (*httpGetter)(nil)
+)
@@ -0,0 +1,68 @@
+package gocache
+
+import (
+	pb "gocache/cachepb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestHTTPPoolServeMux tests that the pool serves peers from a plain
+// net/http ServeMux.
+func TestHTTPPoolServeMux(t *testing.T) {
+	g := NewGroup("servemux", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	defer g.Close()
+
+	pool := NewHTTPPool("")
+	mux := http.NewServeMux()
+	mux.Handle(defaultBasePath, pool)
+	mux.Handle("/set-peers", pool)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	peer := newHTTPGetter(srv.URL, defaultBasePath, nil, nil)
+	for _, key := range []string{"k1", "a/b"} {
+		res := &pb.Response{}
+		if err := peer.Get(&pb.Request{Group: "servemux", Key: key}, res); err != nil || string(res.GetValue()) != "value of "+key {
+			t.Fatalf("expected the value of %s, got %q, %v", key, res.GetValue(), err)
+		}
+	}
+	if err := peer.Get(&pb.Request{Group: "servemux-unknown", Key: "k1"}, &pb.Response{}); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("expected 400 for an unknown group, got %v", err)
+	}
+	scan := &pb.ScanResponse{}
+	if err := peer.Scan(&pb.ScanRequest{Group: "servemux", Limit: 10}, scan); err != nil || len(scan.GetKeys()) != 2 {
+		t.Fatalf("expected both keys to be scanned, got %v, %v", scan.GetKeys(), err)
+	}
+	if err := peer.Scan(&pb.ScanRequest{Group: "servemux"}, &pb.ScanResponse{}); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("expected 400 for a scan without a limit, got %v", err)
+	}
+
+	// no group name is taken by the scan route
+	scanned := NewGroup("_scan", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	defer scanned.Close()
+	value := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "_scan", Key: "servemux"}, value); err != nil || string(value.GetValue()) != "value of servemux" {
+		t.Fatalf("expected the group _scan to be served, got %q, %v", value.GetValue(), err)
+	}
+
+	res, err := http.Post(srv.URL+"/set-peers", "application/json", strings.NewReader(`["http://a:1","http://b:2"]`))
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("expected set-peers to succeed, got %v, %v", res, err)
+	}
+	res.Body.Close()
+	if peers := pool.Peers(); len(peers) != 2 || peers[1] != "http://b:2" {
+		t.Fatalf("expected the pushed peers, got %v", peers)
+	}
+
+	res, err = http.Get(srv.URL + "/set-peers")
+	if err != nil || res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected GET /set-peers to be not found, got %v, %v", res, err)
+	}
+	res.Body.Close()
+}
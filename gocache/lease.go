@@ -0,0 +1,69 @@
+package gocache
+
+import "sync"
+
+// leases hands out memcache-style lease tokens for cache fills. A miss
+// takes a lease before it calls the getter, an invalidation revokes the
+// outstanding lease of the key, and a fill only lands in the cache while
+// its lease is held. A load that started before a delete thus cannot bring
+// the old value back after it.
+type leases struct {
+	mu     sync.Mutex
+	last   uint64
+	tokens map[string]uint64 // the lease held on each key, if any
+}
+
+// acquire hands out a lease on key, revoking any older one.
+func (l *leases) acquire(key string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.tokens == nil {
+		l.tokens = make(map[string]uint64)
+	}
+	l.last++
+	l.tokens[key] = l.last
+	return l.last
+}
+
+// fill calls add if token is still the lease on key, ending the lease. It
+// reports whether add was called. add runs under the lease lock, so that
+// an invalidation cannot slip in between the check and the fill.
+func (l *leases) fill(key string, token uint64, add func()) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.tokens[key] != token {
+		return false
+	}
+	delete(l.tokens, key)
+	add()
+	return true
+}
+
+// release ends the lease token on key without a fill, e.g. after the
+// getter failed.
+func (l *leases) release(key string, token uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.tokens[key] == token {
+		delete(l.tokens, key)
+	}
+}
+
+// revoke revokes the lease on key, if any.
+func (l *leases) revoke(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.tokens, key)
+}
+
+// revokeAll revokes every outstanding lease.
+func (l *leases) revokeAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = nil
+}
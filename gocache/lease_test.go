@@ -0,0 +1,121 @@
+package gocache
+
+import (
+	"testing"
+)
+
+// blockingGetter hands each load a channel to wait on, so tests can decide
+// exactly when a load returns.
+type blockingGetter struct {
+	entered chan chan string // a load waiting for its value
+}
+
+func newBlockingGetter() *blockingGetter {
+	return &blockingGetter{entered: make(chan chan string)}
+}
+
+func (b *blockingGetter) Get(key string) ([]byte, error) {
+	value := make(chan string)
+	b.entered <- value
+	return []byte(<-value), nil
+}
+
+// startGet runs g.Get in the background and returns the channel the load
+// waits on and one that receives the result.
+func startGet(g *Group, b *blockingGetter, key string) (chan string, chan string) {
+	result := make(chan string, 1)
+	go func() {
+		view, err := g.Get(key)
+		if err != nil {
+			result <- "error: " + err.Error()
+			return
+		}
+		result <- view.String()
+	}()
+	return <-b.entered, result
+}
+
+// TestLeaseRemove tests that a load started before Remove does not fill
+// the cache after it.
+func TestLeaseRemove(t *testing.T) {
+	b := newBlockingGetter()
+	g := NewGroup("lease-remove", 0, b)
+	defer g.Close()
+
+	value, result := startGet(g, b, "k1")
+	g.Remove("k1")
+	value <- "old"
+	if v := <-result; v != "old" {
+		t.Fatalf("expected the caller to get the loaded value, got %q", v)
+	}
+	if _, ok := g.Lookup("k1"); ok {
+		t.Fatalf("expected the stale value to be dropped")
+	}
+	if n := g.Stats().StaleFills; n != 1 {
+		t.Fatalf("expected 1 stale fill, got %d", n)
+	}
+
+	// the next miss loads and caches again
+	value, result = startGet(g, b, "k1")
+	value <- "new"
+	<-result
+	if v, ok := g.Lookup("k1"); !ok || v.String() != "new" {
+		t.Fatalf("expected new to be cached, got %q, %v", v.String(), ok)
+	}
+}
+
+// TestLeaseOverlap tests that of two overlapping loads, only the one that
+// started after Remove fills the cache, whichever finishes first.
+func TestLeaseOverlap(t *testing.T) {
+	b := newBlockingGetter()
+	g := NewGroup("lease-overlap", 0, b)
+	defer g.Close()
+
+	oldValue, oldResult := startGet(g, b, "k1")
+	g.Remove("k1")
+	newValue, newResult := startGet(g, b, "k1")
+
+	newValue <- "new"
+	<-newResult
+	oldValue <- "old"
+	<-oldResult
+
+	if v, ok := g.Lookup("k1"); !ok || v.String() != "new" {
+		t.Fatalf("expected new to be cached, got %q, %v", v.String(), ok)
+	}
+}
+
+// TestLeaseSet tests that Set wins over a load in flight.
+func TestLeaseSet(t *testing.T) {
+	b := newBlockingGetter()
+	g := NewGroup("lease-set", 0, b)
+	defer g.Close()
+
+	value, result := startGet(g, b, "k1")
+	g.Set("k1", []byte("set"), Meta{})
+	value <- "loaded"
+	<-result
+
+	if v, ok := g.Lookup("k1"); !ok || v.String() != "set" {
+		t.Fatalf("expected the set value to stay, got %q, %v", v.String(), ok)
+	}
+}
+
+// TestLeasePurge tests that Purge revokes the leases of all keys.
+func TestLeasePurge(t *testing.T) {
+	b := newBlockingGetter()
+	g := NewGroup("lease-purge", 0, b)
+	defer g.Close()
+
+	v1, r1 := startGet(g, b, "k1")
+	v2, r2 := startGet(g, b, "k2")
+	g.Purge()
+	v1 <- "1"
+	v2 <- "2"
+	<-r1
+	<-r2
+
+	if keys, _ := g.Scan("", "", 0); len(keys) != 0 {
+		t.Fatalf("expected an empty cache, got %v", keys)
+	}
+}
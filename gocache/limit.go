@@ -0,0 +1,203 @@
+package gocache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultRetryAfter is what callers shed without a better estimate are
+// told to wait.
+const defaultRetryAfter = time.Second
+
+// ErrOverloaded is matched by an OverloadedError with errors.Is.
+var ErrOverloaded = errors.New("gocache: too many loads")
+
+// OverloadedError reports that a load was shed because the group's load
+// limits were reached. The caller should retry after RetryAfter.
+type OverloadedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("gocache: too many loads, retry after %v", e.RetryAfter)
+}
+
+// Is makes errors.Is(err, ErrOverloaded) hold for an OverloadedError.
+func (e *OverloadedError) Is(target error) bool {
+	return target == ErrOverloaded
+}
+
+// RetryAfter reports how long to wait before asking again if err is a
+// LoadingError or an OverloadedError.
+func RetryAfter(err error) (time.Duration, bool) {
+	var loading *LoadingError
+	if errors.As(err, &loading) {
+		return loading.RetryAfter, true
+	}
+	var overloaded *OverloadedError
+	if errors.As(err, &overloaded) {
+		return overloaded.RetryAfter, true
+	}
+	return 0, false
+}
+
+// LoadLimits bounds the getter calls of a group, so that a cold cache does
+// not pass every miss straight through to the backend. Zero values leave
+// the respective limit off.
+type LoadLimits struct {
+	MaxConcurrent int           `json:"max_concurrent"` // getter calls in flight at once
+	MaxQueue      int           `json:"max_queue"`      // loads waiting for a slot, 0 is no bound
+	QueueTimeout  time.Duration `json:"queue_timeout"`  // how long a load may wait for a slot or token
+	Rate          float64       `json:"rate"`           // getter calls per second
+	Burst         int           `json:"burst"`          // getter calls allowed at once above Rate, at least 1
+}
+
+// loadLimiter enforces a group's LoadLimits.
+type loadLimiter struct {
+	limits LoadLimits
+	slots  chan struct{} // nil if concurrency is not limited
+
+	mu     sync.Mutex // guards queued, tokens and last
+	queued int
+	tokens float64
+	last   time.Time
+}
+
+func newLoadLimiter(limits LoadLimits) *loadLimiter {
+	if limits.Burst < 1 {
+		limits.Burst = 1
+	}
+	l := &loadLimiter{
+		limits: limits,
+		tokens: float64(limits.Burst),
+		last:   time.Now(),
+	}
+	if limits.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	return l
+}
+
+// acquire waits for a getter call to be allowed, at most QueueTimeout, and
+// reports whether it had to wait. Every successful acquire must be paired
+// with a release.
+func (l *loadLimiter) acquire() (waited bool, err error) {
+	deadline := time.Now().Add(l.limits.QueueTimeout)
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if !l.enqueue() {
+				return false, &OverloadedError{RetryAfter: l.retryAfter()}
+			}
+			waited = true
+			timer := time.NewTimer(l.limits.QueueTimeout)
+			select {
+			case l.slots <- struct{}{}:
+				timer.Stop()
+				l.dequeue()
+			case <-timer.C:
+				l.dequeue()
+				return waited, &OverloadedError{RetryAfter: l.retryAfter()}
+			}
+		}
+	}
+
+	if l.limits.Rate > 0 {
+		wait, ok := l.reserve(time.Until(deadline))
+		if !ok {
+			if l.slots != nil {
+				<-l.slots
+			}
+			return waited, &OverloadedError{RetryAfter: wait}
+		}
+		if wait > 0 {
+			waited = true
+			time.Sleep(wait)
+		}
+	}
+
+	return waited, nil
+}
+
+// release ends a getter call admitted by acquire.
+func (l *loadLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// enqueue takes a place in the queue for a slot, if there is room and
+// loads may wait at all.
+func (l *loadLimiter) enqueue() bool {
+	if l.limits.QueueTimeout <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limits.MaxQueue > 0 && l.queued >= l.limits.MaxQueue {
+		return false
+	}
+	l.queued++
+	return true
+}
+
+func (l *loadLimiter) dequeue() {
+	l.mu.Lock()
+	l.queued--
+	l.mu.Unlock()
+}
+
+// reserve takes a token from the bucket, returning how long the caller has
+// to wait for it. If that is longer than maxWait, no token is taken and ok
+// is false.
+func (l *loadLimiter) reserve(maxWait time.Duration) (wait time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.limits.Rate
+	if burst := float64(l.limits.Burst); l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	// the token goes negative, so later callers queue up behind this one
+	wait = time.Duration((1 - l.tokens) / l.limits.Rate * float64(time.Second))
+	if wait > maxWait {
+		return wait, false
+	}
+	l.tokens--
+	return wait, true
+}
+
+// retryAfter estimates when a shed load could be admitted.
+func (l *loadLimiter) retryAfter() time.Duration {
+	if l.limits.QueueTimeout > 0 {
+		return l.limits.QueueTimeout
+	}
+	return defaultRetryAfter
+}
+
+// SetLoadLimits bounds the group's getter calls. Loads beyond the limits
+// wait up to limits.QueueTimeout and then fail with an OverloadedError,
+// which peers asking this node receive as 503 Service Unavailable. Loads
+// already in flight are not affected.
+func (g *Group) SetLoadLimits(limits LoadLimits) {
+	g.limiter.Store(newLoadLimiter(limits))
+}
+
+// LoadLimits returns the limits set by SetLoadLimits.
+func (g *Group) LoadLimits() LoadLimits {
+	if l := g.limiter.Load(); l != nil {
+		return l.limits
+	}
+	return LoadLimits{}
+}
@@ -0,0 +1,136 @@
+package gocache
+
+import (
+	"errors"
+	pb "gocache/cachepb"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queued returns the number of loads waiting for a slot.
+func queued(g *Group) int {
+	l := g.limiter.Load()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queued
+}
+
+// TestLoadLimitsConcurrency tests that loads beyond MaxConcurrent queue up
+// to MaxQueue and QueueTimeout, and are shed beyond that.
+func TestLoadLimitsConcurrency(t *testing.T) {
+	b := newBlockingGetter()
+	g := NewGroup("limit-concurrency", 0, b)
+	defer g.Close()
+	g.SetLoadLimits(LoadLimits{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: time.Second})
+
+	v1, r1 := startGet(g, b, "k1")
+
+	// k2 waits for the slot of k1
+	r2 := make(chan string, 1)
+	go func() {
+		view, _ := g.Get("k2")
+		r2 <- view.String()
+	}()
+	for queued(g) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the queue is full, k3 is shed at once
+	_, err := g.Get("k3")
+	if retry, ok := RetryAfter(err); !errors.Is(err, ErrOverloaded) || !ok || retry != time.Second {
+		t.Fatalf("expected k3 to be shed, got %v", err)
+	}
+
+	v1 <- "1"
+	v2 := <-b.entered
+	v2 <- "2"
+	if <-r1 != "1" || <-r2 != "2" {
+		t.Fatalf("expected the admitted loads to succeed")
+	}
+
+	stats := g.Stats()
+	if stats.LoadsQueued != 1 || stats.LoadsShed != 1 || stats.LocalLoads != 2 || stats.LocalLoadErrs != 0 || stats.LoadsInFlight != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// TestLoadLimitsQueueTimeout tests that a queued load gives up after
+// QueueTimeout.
+func TestLoadLimitsQueueTimeout(t *testing.T) {
+	b := newBlockingGetter()
+	g := NewGroup("limit-timeout", 0, b)
+	defer g.Close()
+	g.SetLoadLimits(LoadLimits{MaxConcurrent: 1, QueueTimeout: 10 * time.Millisecond})
+
+	v1, r1 := startGet(g, b, "k1")
+	if _, err := g.Get("k2"); !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("expected k2 to time out in the queue, got %v", err)
+	}
+	v1 <- "1"
+	<-r1
+	if n := g.Stats().LoadsShed; n != 1 {
+		t.Fatalf("expected 1 shed load, got %d", n)
+	}
+}
+
+// TestLoadLimitsRate tests that the token bucket sheds loads beyond Burst
+// that cannot wait for a token.
+func TestLoadLimitsRate(t *testing.T) {
+	g := NewGroup("limit-rate", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer g.Close()
+	g.SetLoadLimits(LoadLimits{Rate: 1, Burst: 2})
+
+	for _, key := range []string{"k1", "k2"} {
+		if _, err := g.Get(key); err != nil {
+			t.Fatalf("expected %s to fit in the burst, got %v", key, err)
+		}
+	}
+	_, err := g.Get("k3")
+	if retry, ok := RetryAfter(err); !ok || retry <= 0 || retry > time.Second {
+		t.Fatalf("expected k3 to be shed for up to a second, got %v", err)
+	}
+
+	// a token comes back within a queue timeout of a second
+	g.SetLoadLimits(LoadLimits{Rate: 100, Burst: 1, QueueTimeout: time.Second})
+	for _, key := range []string{"k3", "k4"} {
+		if _, err := g.Get(key); err != nil {
+			t.Fatalf("expected %s to wait for a token, got %v", key, err)
+		}
+	}
+	if n := g.Stats().LoadsQueued; n != 1 {
+		t.Fatalf("expected 1 queued load, got %d", n)
+	}
+}
+
+// TestLoadShedding tests that peers get 503 with Retry-After from a
+// saturated node and see it as an OverloadedError.
+func TestLoadShedding(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	b := newBlockingGetter()
+	g := NewGroup("limit-shed", 0, b)
+	defer g.Close()
+	g.SetLoadLimits(LoadLimits{MaxConcurrent: 1})
+
+	r := gin.New()
+	NewHTTPPool("").LoadRouters(r)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	v1, r1 := startGet(g, b, "k1")
+	err := peer.Get(&pb.Request{Group: "limit-shed", Key: "k2"}, &pb.Response{})
+	if retry, ok := RetryAfter(err); !errors.Is(err, ErrOverloaded) || !ok || retry != time.Second {
+		t.Fatalf("expected the peer to be shed, got %v", err)
+	}
+	v1 <- "1"
+	<-r1
+
+	if n := g.Stats().ServerShed; n != 1 {
+		t.Fatalf("expected 1 shed request, got %d", n)
+	}
+}
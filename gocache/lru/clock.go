@@ -0,0 +1,198 @@
+package lru
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Clock is a cache evicting with the CLOCK (second chance) approximation
+// of LRU. A hit only sets the entry's reference bit atomically instead of
+// moving the entry, so Get, Peek and Contains may run concurrently with
+// each other, e.g. under a read lock. All other methods need exclusive
+// access.
+type Clock[K comparable, V any] struct {
+	maxBytes  int64
+	nbytes    int64
+	overhead  int64
+	size      func(key K, value V) int64
+	hand      *clockEntry[K, V] // next entry considered for eviction, nil if empty
+	items     map[K]*clockEntry[K, V]
+	OnEvicted func(key K, value V)
+}
+
+// clockEntry is an entry and its place on the clock face.
+type clockEntry[K comparable, V any] struct {
+	key        K
+	value      V
+	referenced atomic.Bool
+	prev, next *clockEntry[K, V]
+}
+
+// NewClock is the constructor of Clock, see NewTyped for size.
+func NewClock[K comparable, V any](maxBytes int64, size func(K, V) int64, onEvicted func(K, V)) *Clock[K, V] {
+	var (
+		e   clockEntry[K, V]
+		ptr *clockEntry[K, V]
+	)
+	slot := unsafe.Sizeof(e.key) + unsafe.Sizeof(ptr) + mapSlotSlack
+	return &Clock[K, V]{
+		maxBytes:  maxBytes,
+		overhead:  int64(unsafe.Sizeof(e) + slot),
+		size:      size,
+		items:     make(map[K]*clockEntry[K, V]),
+		OnEvicted: onEvicted,
+	}
+}
+
+// sizeOf returns the bytes accounted for an entry.
+func (c *Clock[K, V]) sizeOf(key K, value V) int64 {
+	if c.size == nil {
+		return c.overhead
+	}
+	return c.overhead + c.size(key, value)
+}
+
+// Get looks up a key's value and marks it referenced
+func (c *Clock[K, V]) Get(key K) (value V, ok bool) {
+	if e, ok := c.items[key]; ok {
+		if !e.referenced.Load() {
+			// skip the store if already set, saving cache line traffic
+			e.referenced.Store(true)
+		}
+		return e.value, true
+	}
+	return
+}
+
+// Peek looks up a key's value without marking it referenced
+func (c *Clock[K, V]) Peek(key K) (value V, ok bool) {
+	if e, ok := c.items[key]; ok {
+		return e.value, true
+	}
+	return
+}
+
+// Contains reports whether key is in the cache, without marking it referenced
+func (c *Clock[K, V]) Contains(key K) bool {
+	_, ok := c.items[key]
+	return ok
+}
+
+// Add adds a value to the cache
+func (c *Clock[K, V]) Add(key K, value V) {
+	if e, ok := c.items[key]; ok {
+		c.nbytes += c.sizeOf(key, value) - c.sizeOf(key, e.value)
+		e.value = value
+		e.referenced.Store(true)
+	} else {
+		// new entries go behind the hand, the last place it reaches
+		e := &clockEntry[K, V]{key: key, value: value}
+		if c.hand == nil {
+			e.prev, e.next = e, e
+			c.hand = e
+		} else {
+			e.prev, e.next = c.hand.prev, c.hand
+			c.hand.prev.next = e
+			c.hand.prev = e
+		}
+		c.items[key] = e
+		c.nbytes += c.sizeOf(key, value)
+	}
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.RemoveOldest()
+	}
+}
+
+// RemoveOldest advances the hand, giving referenced entries a second
+// chance, and evicts the first unreferenced entry
+func (c *Clock[K, V]) RemoveOldest() {
+	for c.hand != nil {
+		e := c.hand
+		if e.referenced.Load() {
+			e.referenced.Store(false)
+			c.hand = e.next
+			continue
+		}
+		c.removeEntry(e)
+		return
+	}
+}
+
+// Remove removes the provided key from the cache, reporting whether it
+// was present
+func (c *Clock[K, V]) Remove(key K) bool {
+	if e, ok := c.items[key]; ok {
+		c.removeEntry(e)
+		return true
+	}
+	return false
+}
+
+func (c *Clock[K, V]) removeEntry(e *clockEntry[K, V]) {
+	if e.next == e {
+		c.hand = nil
+	} else {
+		if c.hand == e {
+			c.hand = e.next
+		}
+		e.prev.next, e.next.prev = e.next, e.prev
+	}
+	e.prev, e.next = nil, nil
+	delete(c.items, e.key)
+	c.nbytes -= c.sizeOf(e.key, e.value)
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value)
+	}
+}
+
+// Keys returns the keys of the cache in the order the hand reaches them
+func (c *Clock[K, V]) Keys() []K {
+	keys := make([]K, 0, len(c.items))
+	c.Range(func(key K, value V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Range calls f for each entry, in the order the hand reaches them, until
+// f returns false. The cache must not be modified by f.
+func (c *Clock[K, V]) Range(f func(key K, value V) bool) {
+	if c.hand == nil {
+		return
+	}
+	for e := c.hand; ; {
+		if !f(e.key, e.value) {
+			return
+		}
+		if e = e.next; e == c.hand {
+			return
+		}
+	}
+}
+
+// Purge removes all entries, calling OnEvicted for each of them
+func (c *Clock[K, V]) Purge() {
+	for c.hand != nil {
+		c.removeEntry(c.hand)
+	}
+}
+
+// Resize changes the maximum memory of the cache, 0 meaning no limit.
+// Shrinking evicts entries right away.
+func (c *Clock[K, V]) Resize(maxBytes int64) {
+	c.maxBytes = maxBytes
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.RemoveOldest()
+	}
+}
+
+// Len returns the number of cache entries
+func (c *Clock[K, V]) Len() int {
+	return len(c.items)
+}
+
+// Bytes returns the memory used by the cache entries, overhead included.
+func (c *Clock[K, V]) Bytes() int64 {
+	return c.nbytes
+}
@@ -0,0 +1,78 @@
+package lru
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// TestClockSecondChance tests that referenced entries survive one sweep
+func TestClockSecondChance(t *testing.T) {
+	var evicted []string
+	size := func(key string, value string) int64 { return int64(len(key) + len(value)) }
+	lru := NewClock[string, string](0, size, func(key, value string) {
+		evicted = append(evicted, key)
+	})
+	lru.Add("k1", "v1")
+	lru.Add("k2", "v2")
+	lru.Add("k3", "v3")
+	lru.Get("k1")
+
+	lru.RemoveOldest()
+	lru.RemoveOldest()
+	if !reflect.DeepEqual(evicted, []string{"k2", "k3"}) || !lru.Contains("k1") {
+		t.Fatalf("expected k2 and k3 to be evicted first, got %v", evicted)
+	}
+	lru.RemoveOldest()
+	if lru.Len() != 0 || lru.Bytes() != 0 {
+		t.Fatalf("expected an empty cache, got %v and %d bytes", lru.Keys(), lru.Bytes())
+	}
+}
+
+// TestClockMaxBytes tests that adds evict down to maxBytes
+func TestClockMaxBytes(t *testing.T) {
+	size := func(key string, value string) int64 { return int64(len(key) + len(value)) }
+	overhead := NewClock[string, string](0, nil, nil).overhead
+	lru := NewClock[string, string](2*(overhead+4), size, nil)
+	lru.Add("k1", "v1")
+	lru.Add("k2", "v2")
+	lru.Get("k1")
+	lru.Add("k3", "v3")
+	if !lru.Contains("k1") || lru.Contains("k2") || !lru.Contains("k3") {
+		t.Fatalf("expected k2 to be evicted, got %v", lru.Keys())
+	}
+
+	lru.Resize(overhead + 4)
+	if lru.Len() != 1 {
+		t.Fatalf("expected 1 entry after shrinking, got %v", lru.Keys())
+	}
+	if lru.Remove("k1") && lru.Remove("k3") {
+		t.Fatalf("only one of k1 and k3 can be left")
+	}
+	if keys, _ := Scan[string](lru, "k", "", 0); len(keys) != 0 {
+		t.Fatalf("expected an empty scan, got %v", keys)
+	}
+}
+
+// TestClockConcurrentGet tests that Get may run concurrently with itself
+func TestClockConcurrentGet(t *testing.T) {
+	lru := NewClock[string, int](0, nil, nil)
+	for i, k := range benchKeys {
+		lru.Add(k, i)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, k := range benchKeys {
+				if v, ok := lru.Get(k); !ok || v != i {
+					t.Errorf("Get %s = %d, %t", k, v, ok)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
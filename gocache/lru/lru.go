@@ -1,19 +1,16 @@
 package lru
 
-import "container/list"
+import (
+	"container/heap"
+	"strings"
+)
 
 // Cache is a LRU cache. It is not safe for concurrent access.
+//
+// Cache is a thin wrapper of Typed for values of interface type Value;
+// Typed avoids boxing each value and the type assertions on Get.
 type Cache struct {
-	maxBytes  int64 // 允许使用的最大内存，0 表示无限制
-	nbytes    int64 // 当前已使用的内存
-	ll        *list.List
-	cache     map[string]*list.Element
-	OnEvicted func(key string, value Value) // 某条记录被移除时的回调函数，可以为 nil
-}
-
-type entry struct {
-	key   string
-	value Value
+	*Typed[string, Value]
 }
 
 // Value use Len to count how many bytes it takes
@@ -21,65 +18,87 @@ type Value interface {
 	Len() int
 }
 
+// Sizer is implemented by values that occupy more memory than Len reports,
+// e.g. because they carry a header besides their data. Size is then used
+// instead of Len for accounting.
+type Sizer interface {
+	Size() int64
+}
+
+// entryOverhead is the memory each entry of a Cache costs besides its key
+// and value: the entry struct, its list links and the map slot.
+var entryOverhead = overheadOf[string, Value]()
+
+// sizeOf returns the bytes accounted for a key and its value.
+func sizeOf(key string, value Value) int64 {
+	return int64(len(key)) + valueSize(value)
+}
+
+// valueSize returns the bytes accounted for a value.
+func valueSize(value Value) int64 {
+	if s, ok := value.(Sizer); ok {
+		return s.Size()
+	}
+	return int64(value.Len())
+}
+
 // New is the constructor of Cache
 func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
-	return &Cache{
-		maxBytes:  maxBytes,
-		ll:        list.New(),
-		cache:     make(map[string]*list.Element),
-		OnEvicted: onEvicted,
-	}
+	return &Cache{NewTyped[string, Value](maxBytes, sizeOf, onEvicted)}
 }
 
-// Get look up a key's value
-func (c *Cache) Get(key string) (value Value, ok bool) {
-	// 从字典中找到对应的双向链表的节点
-	if ele, ok := c.cache[key]; ok {
-		// 将该节点移动到队头
-		c.ll.MoveToFront(ele)
-		// 取出节点的值
-		kv := ele.Value.(*entry)
-		return kv.value, true
-	}
-	return
+// Scan returns up to limit keys starting with prefix that sort after
+// cursor, in ascending order, and the cursor to pass to continue the
+// scan, which is empty once there are no more keys. An empty cursor
+// starts from the beginning. Every call walks the whole cache, but a
+// long scan is split into calls so that callers need not hold a lock
+// across it. A limit <= 0 returns all matching keys.
+func (c *Cache) Scan(prefix, cursor string, limit int) (keys []string, next string) {
+	return Scan[Value](c.Typed, prefix, cursor, limit)
 }
 
-// RemoveOldest removes the oldest item
-func (c *Cache) RemoveOldest() {
-	ele := c.ll.Back()
-	if ele != nil {
-		c.ll.Remove(ele)
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
+// scanKeys implements Scan over the keys produced by each, so that other
+// key stores can share its paging semantics.
+func scanKeys(prefix, cursor string, limit int, each func(yield func(key string))) (keys []string, next string) {
+	var (
+		h    keyHeap // the limit smallest matching keys, largest on top
+		more bool
+	)
+	each(func(key string) {
+		if key <= cursor || !strings.HasPrefix(key, prefix) {
+			return
 		}
-	}
-}
+		if limit <= 0 || h.Len() < limit {
+			heap.Push(&h, key)
+			return
+		}
+		more = true
+		if key < h[0] {
+			h[0] = key
+			heap.Fix(&h, 0)
+		}
+	})
 
-// Add adds a value to the cache
-func (c *Cache) Add(key string, value Value) {
-	// 如果键存在，则更新对应节点的值，并将该节点移到队头
-	if ele, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
-		// 更新节点的值
-		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
-		kv.value = value
-	} else {
-		// 如果键不存在，则在队头添加新节点
-		ele := c.ll.PushFront(&entry{key, value})
-		c.cache[key] = ele
-		c.nbytes += int64(len(key)) + int64(value.Len())
+	keys = make([]string, h.Len())
+	for i := len(keys) - 1; i >= 0; i-- {
+		keys[i] = heap.Pop(&h).(string)
 	}
-	// 如果超过了设定的最大内存，则移除最少访问的节点
-	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
-		c.RemoveOldest()
+	if more {
+		next = keys[len(keys)-1]
 	}
+	return keys, next
 }
 
-// Len returns the number of cache entries
-func (c *Cache) Len() int {
-	return c.ll.Len()
+// keyHeap is a max-heap of keys.
+type keyHeap []string
+
+func (h keyHeap) Len() int            { return len(h) }
+func (h keyHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h keyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keyHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
 }
@@ -27,8 +27,8 @@ func TestGet(t *testing.T) {
 func TestRemoveOldest(t *testing.T) {
 	k1, k2, k3 := "key1", "key2", "key3"
 	v1, v2, v3 := "val1", "val2", "val3"
-	cap := len(k1 + k2 + v1 + v2)
-	lru := New(int64(cap), nil)
+	cap := int64(len(k1+k2+v1+v2)) + 2*entryOverhead
+	lru := New(cap, nil)
 	lru.Add(k1, String(v1))
 	lru.Add(k2, String(v2))
 	lru.Add(k3, String(v3))
@@ -43,7 +43,7 @@ func TestOnEvicted(t *testing.T) {
 	callback := func(key string, value Value) {
 		keys = append(keys, key)
 	}
-	lru := New(int64(10), callback)
+	lru := New(10+2*entryOverhead, callback)
 	lru.Add("key1", String("123456"))
 	lru.Add("k2", String("v2"))
 	lru.Add("k3", String("v3"))
@@ -61,7 +61,141 @@ func TestAdd(t *testing.T) {
 	lru := New(int64(0), nil)
 	lru.Add("key", String("1"))
 	lru.Add("key", String("1234"))
-	if lru.nbytes != int64(len("key")+len("1234")) {
-		t.Fatalf("expected 7 but got %d", lru.nbytes)
+	if expect := int64(len("key")+len("1234")) + entryOverhead; lru.nbytes != expect {
+		t.Fatalf("expected %d but got %d", expect, lru.nbytes)
+	}
+}
+
+type sized string
+
+func (d sized) Len() int {
+	return len(d)
+}
+
+func (d sized) Size() int64 {
+	return int64(len(d)) + 16
+}
+
+// TestSizer tests that a value's Size is preferred over its Len
+func TestSizer(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key", sized("1234"))
+	if expect := int64(len("key")+len("1234")+16) + entryOverhead; lru.Bytes() != expect {
+		t.Fatalf("expected %d but got %d", expect, lru.Bytes())
+	}
+	lru.RemoveOldest()
+	if lru.Bytes() != 0 {
+		t.Fatalf("expected 0 but got %d", lru.Bytes())
+	}
+}
+
+// TestScan tests that keys are paged in order with a cursor
+func TestScan(t *testing.T) {
+	lru := New(int64(0), nil)
+	for _, k := range []string{"user:3", "user:1", "item:1", "user:2", "user:4"} {
+		lru.Add(k, String("v"))
+	}
+
+	var got []string
+	cursor := ""
+	for {
+		keys, next := lru.Scan("user:", cursor, 3)
+		got = append(got, keys...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	expect := []string{"user:1", "user:2", "user:3", "user:4"}
+	if !reflect.DeepEqual(expect, got) {
+		t.Fatalf("expected %v, got %v", expect, got)
+	}
+
+	if keys, next := lru.Scan("", "", 0); len(keys) != 5 || next != "" {
+		t.Fatalf("unlimited scan got %v, %q", keys, next)
+	}
+}
+
+// TestRemove tests that a key can be removed and its bytes released
+func TestRemove(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1234"))
+	lru.Add("key2", String("5678"))
+	if !lru.Remove("key1") || lru.Remove("key1") {
+		t.Fatalf("Remove key1 failed")
+	}
+	if lru.Contains("key1") || lru.Len() != 1 {
+		t.Fatalf("key1 still cached")
+	}
+	if expect := int64(len("key2")+len("5678")) + entryOverhead; lru.Bytes() != expect {
+		t.Fatalf("expected %d bytes but got %d", expect, lru.Bytes())
+	}
+}
+
+// TestPeek tests that Peek and Contains leave the recency untouched
+func TestPeek(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1"))
+	lru.Add("key2", String("2"))
+	if v, ok := lru.Peek("key1"); !ok || string(v.(String)) != "1" || !lru.Contains("key1") {
+		t.Fatalf("Peek key1 failed")
+	}
+	if _, ok := lru.Peek("key3"); ok || lru.Contains("key3") {
+		t.Fatalf("Peek key3 should miss")
+	}
+	lru.RemoveOldest()
+	if lru.Contains("key1") {
+		t.Fatalf("Peek updated the recency of key1")
+	}
+}
+
+// TestKeys tests that keys are listed from oldest to newest
+func TestKeys(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1"))
+	lru.Add("key2", String("2"))
+	lru.Add("key3", String("3"))
+	lru.Get("key1")
+	expect := []string{"key2", "key3", "key1"}
+	if keys := lru.Keys(); !reflect.DeepEqual(expect, keys) {
+		t.Fatalf("expected %v, got %v", expect, keys)
+	}
+}
+
+// TestPurge tests that Purge empties the cache and reports every entry
+func TestPurge(t *testing.T) {
+	evicted := 0
+	lru := New(int64(0), func(string, Value) { evicted++ })
+	lru.Add("key1", String("1"))
+	lru.Add("key2", String("2"))
+	lru.Purge()
+	if lru.Len() != 0 || lru.Bytes() != 0 || evicted != 2 {
+		t.Fatalf("Purge left %d entries, %d bytes, evicted %d", lru.Len(), lru.Bytes(), evicted)
+	}
+}
+
+// TestResize tests that shrinking evicts right away and growing keeps entries
+func TestResize(t *testing.T) {
+	size := int64(len("key1")+len("1234")) + entryOverhead
+	lru := New(3*size, nil)
+	lru.Add("key1", String("1234"))
+	lru.Add("key2", String("1234"))
+	lru.Add("key3", String("1234"))
+
+	lru.Resize(size)
+	if lru.Len() != 1 || !lru.Contains("key3") {
+		t.Fatalf("Resize kept %v", lru.Keys())
+	}
+
+	lru.Resize(2 * size)
+	lru.Add("key4", String("1234"))
+	if lru.Len() != 2 {
+		t.Fatalf("Resize to a larger size should keep 2 entries, got %v", lru.Keys())
+	}
+
+	lru.Resize(0)
+	lru.Add("key5", String("1234"))
+	if lru.Len() != 3 {
+		t.Fatalf("Resize to 0 should remove the limit, got %v", lru.Keys())
 	}
 }
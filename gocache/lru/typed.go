@@ -0,0 +1,210 @@
+package lru
+
+import "unsafe"
+
+// Typed is a LRU cache keyed by K holding values of type V. Values are
+// stored as is, without the interface boxing of Cache. It is not safe for
+// concurrent access.
+type Typed[K comparable, V any] struct {
+	maxBytes  int64 // 允许使用的最大内存，0 表示无限制
+	nbytes    int64 // 当前已使用的内存，包括每条记录的额外开销
+	overhead  int64 // 每条记录的额外开销
+	size      func(key K, value V) int64
+	root      typedEntry[K, V] // 双向链表的哨兵节点，root.next 为队头
+	items     map[K]*typedEntry[K, V]
+	OnEvicted func(key K, value V) // 某条记录被移除时的回调函数，可以为 nil
+}
+
+// typedEntry is an entry and its node in the recency list.
+type typedEntry[K comparable, V any] struct {
+	key        K
+	value      V
+	prev, next *typedEntry[K, V]
+}
+
+// mapSlotSlack approximates the tophash byte of a map slot and the unused
+// slots kept by the map's load factor.
+const mapSlotSlack = 8
+
+// overheadOf returns the memory each entry costs besides what the size
+// function counts: the entry struct and its map slot.
+func overheadOf[K comparable, V any]() int64 {
+	var (
+		e   typedEntry[K, V]
+		ptr *typedEntry[K, V]
+	)
+	slot := unsafe.Sizeof(e.key) + unsafe.Sizeof(ptr) + mapSlotSlack
+	return int64(unsafe.Sizeof(e) + slot)
+}
+
+// NewTyped is the constructor of Typed. size returns the bytes a key and
+// its value occupy besides the per-entry overhead, which is accounted for
+// automatically; a nil size counts the overhead only.
+func NewTyped[K comparable, V any](maxBytes int64, size func(K, V) int64, onEvicted func(K, V)) *Typed[K, V] {
+	c := &Typed[K, V]{
+		maxBytes:  maxBytes,
+		overhead:  overheadOf[K, V](),
+		size:      size,
+		items:     make(map[K]*typedEntry[K, V]),
+		OnEvicted: onEvicted,
+	}
+	c.root.next, c.root.prev = &c.root, &c.root
+	return c
+}
+
+// sizeOf returns the bytes accounted for an entry.
+func (c *Typed[K, V]) sizeOf(key K, value V) int64 {
+	if c.size == nil {
+		return c.overhead
+	}
+	return c.overhead + c.size(key, value)
+}
+
+// Get look up a key's value
+func (c *Typed[K, V]) Get(key K) (value V, ok bool) {
+	// 从字典中找到对应的双向链表的节点
+	if e, ok := c.items[key]; ok {
+		// 将该节点移动到队头
+		c.moveToFront(e)
+		// 取出节点的值
+		return e.value, true
+	}
+	return
+}
+
+// Peek looks up a key's value without updating its recency
+func (c *Typed[K, V]) Peek(key K) (value V, ok bool) {
+	if e, ok := c.items[key]; ok {
+		return e.value, true
+	}
+	return
+}
+
+// Contains reports whether key is in the cache, without updating its recency
+func (c *Typed[K, V]) Contains(key K) bool {
+	_, ok := c.items[key]
+	return ok
+}
+
+// Keys returns the keys of the cache, from oldest to newest
+func (c *Typed[K, V]) Keys() []K {
+	keys := make([]K, 0, len(c.items))
+	for e := c.root.prev; e != &c.root; e = e.prev {
+		keys = append(keys, e.key)
+	}
+	return keys
+}
+
+// Range calls f for each entry, from newest to oldest, until f returns
+// false. The cache must not be modified by f.
+func (c *Typed[K, V]) Range(f func(key K, value V) bool) {
+	for e := c.root.next; e != &c.root; e = e.next {
+		if !f(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// Purge removes all entries, calling OnEvicted for each of them
+func (c *Typed[K, V]) Purge() {
+	for len(c.items) > 0 {
+		c.RemoveOldest()
+	}
+}
+
+// RemoveOldest removes the oldest item
+func (c *Typed[K, V]) RemoveOldest() {
+	if e := c.root.prev; e != &c.root {
+		c.removeEntry(e)
+	}
+}
+
+// Remove removes the provided key from the cache, reporting whether it
+// was present
+func (c *Typed[K, V]) Remove(key K) bool {
+	if e, ok := c.items[key]; ok {
+		c.removeEntry(e)
+		return true
+	}
+	return false
+}
+
+func (c *Typed[K, V]) removeEntry(e *typedEntry[K, V]) {
+	e.prev.next, e.next.prev = e.next, e.prev
+	e.prev, e.next = nil, nil
+	delete(c.items, e.key)
+	c.nbytes -= c.sizeOf(e.key, e.value)
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value)
+	}
+}
+
+// Add adds a value to the cache
+func (c *Typed[K, V]) Add(key K, value V) {
+	// 如果键存在，则更新对应节点的值，并将该节点移到队头
+	if e, ok := c.items[key]; ok {
+		c.moveToFront(e)
+		// 更新节点的值
+		c.nbytes += c.sizeOf(key, value) - c.sizeOf(key, e.value)
+		e.value = value
+	} else {
+		// 如果键不存在，则在队头添加新节点
+		e := &typedEntry[K, V]{key: key, value: value}
+		c.pushFront(e)
+		c.items[key] = e
+		c.nbytes += c.sizeOf(key, value)
+	}
+	// 如果超过了设定的最大内存，则移除最少访问的节点
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.RemoveOldest()
+	}
+}
+
+// Resize changes the maximum memory of the cache, 0 meaning no limit.
+// Shrinking evicts the oldest entries right away.
+func (c *Typed[K, V]) Resize(maxBytes int64) {
+	c.maxBytes = maxBytes
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.RemoveOldest()
+	}
+}
+
+// Len returns the number of cache entries
+func (c *Typed[K, V]) Len() int {
+	return len(c.items)
+}
+
+// Bytes returns the memory used by the cache entries, overhead included.
+func (c *Typed[K, V]) Bytes() int64 {
+	return c.nbytes
+}
+
+func (c *Typed[K, V]) pushFront(e *typedEntry[K, V]) {
+	e.prev, e.next = &c.root, c.root.next
+	c.root.next.prev = e
+	c.root.next = e
+}
+
+func (c *Typed[K, V]) moveToFront(e *typedEntry[K, V]) {
+	if c.root.next == e {
+		return
+	}
+	e.prev.next, e.next.prev = e.next, e.prev
+	c.pushFront(e)
+}
+
+// Ranger is implemented by the caches of this package.
+type Ranger[K comparable, V any] interface {
+	Range(f func(key K, value V) bool)
+}
+
+// Scan returns up to limit keys of c starting with prefix that sort after
+// cursor, see Cache.Scan.
+func Scan[V any](c Ranger[string, V], prefix, cursor string, limit int) (keys []string, next string) {
+	return scanKeys(prefix, cursor, limit, func(yield func(key string)) {
+		c.Range(func(key string, _ V) bool {
+			yield(key)
+			return true
+		})
+	})
+}
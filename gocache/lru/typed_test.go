@@ -0,0 +1,118 @@
+package lru
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// TestTyped tests the generic cache with non-string keys
+func TestTyped(t *testing.T) {
+	var evicted []int
+	size := func(key int, value []byte) int64 { return int64(len(value)) }
+	overhead := overheadOf[int, []byte]()
+	lru := NewTyped[int, []byte](2*(overhead+4), size, func(key int, value []byte) {
+		evicted = append(evicted, key)
+	})
+
+	lru.Add(1, []byte("1234"))
+	lru.Add(2, []byte("5678"))
+	if v, ok := lru.Get(1); !ok || string(v) != "1234" {
+		t.Fatalf("cache hit 1=1234 failed")
+	}
+	lru.Add(3, []byte("9012"))
+
+	if lru.Contains(2) || !reflect.DeepEqual(evicted, []int{2}) {
+		t.Fatalf("expected 2 to be evicted, got %v", evicted)
+	}
+	if keys := lru.Keys(); !reflect.DeepEqual(keys, []int{1, 3}) {
+		t.Fatalf("expected keys [1 3], got %v", keys)
+	}
+	if lru.Bytes() != 2*(overhead+4) {
+		t.Fatalf("expected %d bytes, got %d", 2*(overhead+4), lru.Bytes())
+	}
+
+	var ranged []int
+	lru.Range(func(key int, value []byte) bool {
+		ranged = append(ranged, key)
+		return true
+	})
+	if !reflect.DeepEqual(ranged, []int{3, 1}) {
+		t.Fatalf("expected range [3 1], got %v", ranged)
+	}
+}
+
+// TestTypedScan tests that typed caches with string keys can be scanned
+func TestTypedScan(t *testing.T) {
+	lru := NewTyped[string, int](0, nil, nil)
+	for i := 0; i < 5; i++ {
+		lru.Add("k"+strconv.Itoa(i), i)
+	}
+	keys, next := Scan[int](lru, "k", "k1", 2)
+	if !reflect.DeepEqual(keys, []string{"k2", "k3"}) || next != "k3" {
+		t.Fatalf("unexpected page %v, %q", keys, next)
+	}
+}
+
+var benchKeys = func() []string {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	return keys
+}()
+
+type benchValue struct {
+	b      []byte
+	expire int64
+}
+
+func (v benchValue) Len() int { return len(v.b) }
+
+// BenchmarkCacheAdd and BenchmarkTypedAdd compare the boxing Cache with
+// Typed on the same workload; Typed saves an allocation per Add.
+func BenchmarkCacheAdd(b *testing.B) {
+	lru := New(0, nil)
+	v := benchValue{b: make([]byte, 16)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lru.Add(benchKeys[i%len(benchKeys)], v)
+	}
+}
+
+func BenchmarkTypedAdd(b *testing.B) {
+	lru := NewTyped[string, benchValue](0, func(k string, v benchValue) int64 { return int64(len(k) + v.Len()) }, nil)
+	v := benchValue{b: make([]byte, 16)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		lru.Add(benchKeys[i%len(benchKeys)], v)
+	}
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	lru := New(0, nil)
+	for _, k := range benchKeys {
+		lru.Add(k, benchValue{b: make([]byte, 16)})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if v, ok := lru.Get(benchKeys[i%len(benchKeys)]); !ok || v.(benchValue).Len() != 16 {
+			b.Fatal("miss")
+		}
+	}
+}
+
+func BenchmarkTypedGet(b *testing.B) {
+	lru := NewTyped[string, benchValue](0, nil, nil)
+	for _, k := range benchKeys {
+		lru.Add(k, benchValue{b: make([]byte, 16)})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if v, ok := lru.Get(benchKeys[i%len(benchKeys)]); !ok || v.Len() != 16 {
+			b.Fatal("miss")
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package gocache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxMemcacheKey   = 250
+	maxMemcacheValue = 64 << 20
+	// exptimes beyond 30 days are unix times rather than relative
+	maxMemcacheRelExptime = 60 * 60 * 24 * 30
+)
+
+// errMemcacheClient is a malformed request, answered CLIENT_ERROR.
+var errMemcacheClient = errors.New("bad command line format")
+
+// errMemcacheTooLarge is a value larger than its group may hold, answered
+// SERVER_ERROR after its data block is skipped.
+var errMemcacheTooLarge = errors.New("object too large for cache")
+
+// MemcacheServer serves the groups of this node over the memcached text
+// protocol, so that memcached clients can use gocache unchanged. get,
+// gets, gat, gats, set, delete, touch, stats, version and quit are
+// supported, as are the meta commands mg, ms, md and mn.
+//
+// A key like "scores:Tom" names its group before the separator, other
+// keys belong to DefaultGroup. A get of several keys loads those of a
+// group with Group.GetMany, in a single batch if the group's getter is a
+// BatchGetter. Keys that fail to load read as misses. set, delete and
+// touch act on the local cache only, like Group.Set, Group.Remove and
+// Group.Touch. Client flags are kept in Meta.Flags and the cas unique
+// reported by gets is Meta.Version.
+type MemcacheServer struct {
+	DefaultGroup string // group of keys that name none, may be empty
+	Separator    string // separates the group from the key, ":" if empty
+
+	srv tcpServer
+}
+
+// NewMemcacheServer creates a memcached server serving keys without a
+// group prefix from defaultGroup.
+func NewMemcacheServer(defaultGroup string) *MemcacheServer {
+	return &MemcacheServer{DefaultGroup: defaultGroup}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+// until Close.
+func (s *MemcacheServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves connections accepted on l until l fails or the server is
+// closed, in which case it returns ErrServerClosed.
+func (s *MemcacheServer) Serve(l net.Listener) error {
+	return s.srv.serve(l, s.serveConn)
+}
+
+// Close stops the server, closing its listeners and connections.
+func (s *MemcacheServer) Close() error {
+	return s.srv.close()
+}
+
+// memcacheConn is the state of a client connection.
+type memcacheConn struct {
+	s    *MemcacheServer
+	r    *bufio.Reader
+	w    *bufio.Writer
+	quit bool
+}
+
+func (s *MemcacheServer) serveConn(conn net.Conn) {
+	c := &memcacheConn{
+		s: s,
+		r: bufio.NewReader(conn),
+		w: bufio.NewWriter(conn),
+	}
+	for !c.quit {
+		line, err := c.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			c.w.WriteString("CLIENT_ERROR line too long\r\n")
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if err := c.exec(strings.Fields(string(line))); err != nil {
+			// the data block of a bad storage command cannot be skipped
+			c.w.WriteString("CLIENT_ERROR " + err.Error() + "\r\n")
+			c.w.Flush()
+			return
+		}
+		// pipelined commands are answered together
+		if c.r.Buffered() == 0 || c.quit {
+			if c.w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// exec runs a command. An error closes the connection.
+func (c *memcacheConn) exec(args []string) error {
+	if len(args) == 0 {
+		c.w.WriteString("ERROR\r\n")
+		return nil
+	}
+	for _, key := range args[1:] {
+		if len(key) > maxMemcacheKey {
+			c.w.WriteString("CLIENT_ERROR key too long\r\n")
+			return nil
+		}
+	}
+
+	switch args[0] {
+	case "get", "gets":
+		if len(args) < 2 {
+			c.w.WriteString("ERROR\r\n")
+			return nil
+		}
+		c.execGet(args[1:], args[0] == "gets")
+	case "gat", "gats":
+		if len(args) < 3 {
+			c.w.WriteString("ERROR\r\n")
+			return nil
+		}
+		c.execGat(args[1], args[2:], args[0] == "gats")
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return c.execSet(args)
+	case "delete":
+		c.execDelete(args[1:])
+	case "touch":
+		c.execTouch(args[1:])
+	case "mg":
+		c.execMetaGet(args[1:])
+	case "ms":
+		return c.execMetaSet(args[1:])
+	case "md":
+		c.execMetaDelete(args[1:])
+	case "mn":
+		c.w.WriteString("MN\r\n")
+	case "stats":
+		c.execStats()
+	case "version":
+		c.w.WriteString("VERSION gocache\r\n")
+	case "verbosity":
+		if !noreply(args) {
+			c.w.WriteString("OK\r\n")
+		}
+	case "quit":
+		c.quit = true
+	default:
+		c.w.WriteString("ERROR\r\n")
+	}
+	return nil
+}
+
+// noreply reports whether a command asks not to be answered.
+func noreply(args []string) bool {
+	return len(args) > 0 && args[len(args)-1] == "noreply"
+}
+
+// memcacheTTL converts a memcached exptime to a time to live: 0 never
+// expires, up to 30 days is relative in seconds, beyond that a unix time.
+// expired reports an exptime that has passed already.
+func memcacheTTL(exptime int64) (ttl time.Duration, expired bool) {
+	switch {
+	case exptime < 0:
+		return 0, true
+	case exptime == 0:
+		return 0, false
+	case exptime <= maxMemcacheRelExptime:
+		return time.Duration(exptime) * time.Second, false
+	}
+	ttl = time.Unix(exptime, 0).Sub(nowFunc())
+	return ttl, ttl <= 0
+}
+
+// readData reads the data block of a storage command for key. A block
+// larger than the key's group may hold is skipped.
+func (c *memcacheConn) readData(key string, size int) ([]byte, error) {
+	if size < 0 || size > maxMemcacheValue {
+		return nil, errMemcacheClient
+	}
+	if g, _, err := splitGroupKey(key, c.s.Separator, c.s.DefaultGroup); err == nil && int64(size) > g.valueLimit() {
+		if _, err := io.CopyN(io.Discard, c.r, int64(size)+2); err != nil {
+			return nil, err
+		}
+		return nil, errMemcacheTooLarge
+	}
+	data, ok, err := readBlock(c.r, size)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("bad data chunk")
+	}
+	return data, nil
+}
+
+// getKeys loads keys, grouping them by group so that each group loads its
+// keys with one GetMany. Keys that fail to load are left out.
+func (c *memcacheConn) getKeys(keys []string) []*ByteView {
+	views := make([]*ByteView, len(keys))
+	byGroup := make(map[*Group][]int)
+	local := make([]string, len(keys))
+	for i, key := range keys {
+		g, key, err := splitGroupKey(key, c.s.Separator, c.s.DefaultGroup)
+		if err != nil {
+			continue
+		}
+		local[i] = key
+		byGroup[g] = append(byGroup[g], i)
+	}
+	for g, idx := range byGroup {
+		groupKeys := make([]string, len(idx))
+		for j, i := range idx {
+			groupKeys[j] = local[i]
+		}
+		got, errs := g.GetMany(groupKeys)
+		for j, i := range idx {
+			if errs[j] == nil {
+				views[i] = &got[j]
+			}
+		}
+	}
+	return views
+}
+
+func (c *memcacheConn) writeValue(key string, view *ByteView, cas bool) {
+	meta := view.Meta()
+	c.w.WriteString("VALUE " + key + " " + strconv.FormatUint(uint64(meta.Flags), 10) + " " + strconv.Itoa(view.Len()))
+	if cas {
+		c.w.WriteString(" " + strconv.FormatInt(meta.Version, 10))
+	}
+	c.w.WriteString("\r\n")
+	c.w.Write(view.bytes())
+	c.w.WriteString("\r\n")
+}
+
+func (c *memcacheConn) execGet(keys []string, cas bool) {
+	for i, view := range c.getKeys(keys) {
+		if view != nil {
+			c.writeValue(keys[i], view, cas)
+		}
+	}
+	c.w.WriteString("END\r\n")
+}
+
+// execGat touches the keys cached here and gets them all.
+func (c *memcacheConn) execGat(exptime string, keys []string, cas bool) {
+	exp, err := strconv.ParseInt(exptime, 10, 64)
+	if err != nil {
+		c.w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return
+	}
+	for _, key := range keys {
+		c.touch(key, exp)
+	}
+	c.execGet(keys, cas)
+}
+
+// touch changes the expiry of key, reporting whether it was cached.
+func (c *memcacheConn) touch(key string, exptime int64) bool {
+	g, key, err := splitGroupKey(key, c.s.Separator, c.s.DefaultGroup)
+	if err != nil {
+		return false
+	}
+	ttl, expired := memcacheTTL(exptime)
+	if expired {
+		return g.Remove(key)
+	}
+	return g.Touch(key, ttl)
+}
+
+// store sets a value, removing it if exptime has passed.
+func (c *memcacheConn) store(key string, value []byte, flags uint32, exptime int64) error {
+	g, key, err := splitGroupKey(key, c.s.Separator, c.s.DefaultGroup)
+	if err != nil {
+		return err
+	}
+	ttl, expired := memcacheTTL(exptime)
+	if expired {
+		g.Remove(key)
+		return nil
+	}
+	return g.Set(key, value, Meta{TTL: ttl, Flags: flags})
+}
+
+// execSet runs a storage command: <cmd> <key> <flags> <exptime> <bytes>
+// [<cas unique>] [noreply]. Only set is supported, the others read their
+// data and fail.
+func (c *memcacheConn) execSet(args []string) error {
+	n := 5
+	if args[0] == "cas" {
+		n = 6
+	}
+	if len(args) != n && !(len(args) == n+1 && noreply(args)) {
+		return errMemcacheClient
+	}
+	flags, err1 := strconv.ParseUint(args[2], 10, 32)
+	exptime, err2 := strconv.ParseInt(args[3], 10, 64)
+	size, err3 := strconv.Atoi(args[4])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return errMemcacheClient
+	}
+	data, err := c.readData(args[1], size)
+	if err != nil && err != errMemcacheTooLarge {
+		return err
+	}
+
+	var reply string
+	if err != nil {
+		reply = "SERVER_ERROR " + err.Error()
+	} else if args[0] != "set" {
+		reply = "SERVER_ERROR " + args[0] + " is not supported"
+	} else if err := c.store(args[1], data, uint32(flags), exptime); err != nil {
+		reply = "SERVER_ERROR " + err.Error()
+	} else {
+		reply = "STORED"
+	}
+	if !noreply(args) {
+		c.w.WriteString(reply + "\r\n")
+	}
+	return nil
+}
+
+func (c *memcacheConn) remove(key string) bool {
+	g, key, err := splitGroupKey(key, c.s.Separator, c.s.DefaultGroup)
+	return err == nil && g.Remove(key)
+}
+
+// execDelete runs delete <key> [0] [noreply].
+func (c *memcacheConn) execDelete(args []string) {
+	reply := noreply(args)
+	if reply {
+		args = args[:len(args)-1]
+	}
+	if len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != "0") {
+		c.w.WriteString("CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]\r\n")
+		return
+	}
+	deleted := c.remove(args[0])
+	switch {
+	case reply:
+	case deleted:
+		c.w.WriteString("DELETED\r\n")
+	default:
+		c.w.WriteString("NOT_FOUND\r\n")
+	}
+}
+
+// execTouch runs touch <key> <exptime> [noreply].
+func (c *memcacheConn) execTouch(args []string) {
+	reply := noreply(args)
+	if reply {
+		args = args[:len(args)-1]
+	}
+	if len(args) != 2 {
+		c.w.WriteString("ERROR\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return
+	}
+	touched := c.touch(args[0], exptime)
+	switch {
+	case reply:
+	case touched:
+		c.w.WriteString("TOUCHED\r\n")
+	default:
+		c.w.WriteString("NOT_FOUND\r\n")
+	}
+}
+
+// metaFlags are the flags of a meta command, each a letter with an
+// optional token, e.g. "T30".
+type metaFlags []string
+
+func (f metaFlags) has(flag byte) bool {
+	_, ok := f.token(flag)
+	return ok
+}
+
+func (f metaFlags) token(flag byte) (string, bool) {
+	for _, s := range f {
+		if s[0] == flag {
+			return s[1:], true
+		}
+	}
+	return "", false
+}
+
+// check reports the first flag that is not in supported.
+func (f metaFlags) check(supported string) error {
+	for _, s := range f {
+		if !strings.Contains(supported, s[:1]) {
+			return fmt.Errorf("invalid flag %q", s[:1])
+		}
+	}
+	return nil
+}
+
+// returned formats the flags the client asked to get back, in the order
+// it asked for them.
+func (f metaFlags) returned(key string, view *ByteView) string {
+	var b strings.Builder
+	for _, s := range f {
+		switch s[0] {
+		case 'O':
+			b.WriteString(" " + s)
+		case 'k':
+			b.WriteString(" k" + key)
+		}
+		if view == nil {
+			continue
+		}
+		switch s[0] {
+		case 'c':
+			b.WriteString(" c" + strconv.FormatInt(view.Meta().Version, 10))
+		case 'f':
+			b.WriteString(" f" + strconv.FormatUint(uint64(view.Meta().Flags), 10))
+		case 's':
+			b.WriteString(" s" + strconv.Itoa(view.Len()))
+		case 't':
+			ttl := int64(-1)
+			if d := view.TTL(); d > 0 {
+				ttl = int64((d + time.Second - 1) / time.Second)
+			}
+			b.WriteString(" t" + strconv.FormatInt(ttl, 10))
+		}
+	}
+	return b.String()
+}
+
+// execMetaGet runs mg <key> <flags>*. T<exptime> touches the key first,
+// q leaves misses unanswered.
+func (c *memcacheConn) execMetaGet(args []string) {
+	if len(args) < 1 {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	key, flags := args[0], metaFlags(args[1:])
+	if err := flags.check("cfkOqstTv"); err != nil {
+		c.w.WriteString("CLIENT_ERROR " + err.Error() + "\r\n")
+		return
+	}
+	if exptime, ok := flags.token('T'); ok {
+		exp, err := strconv.ParseInt(exptime, 10, 64)
+		if err != nil {
+			c.w.WriteString("CLIENT_ERROR bad token in command line format\r\n")
+			return
+		}
+		c.touch(key, exp)
+	}
+
+	view := c.getKeys([]string{key})[0]
+	if view == nil {
+		if !flags.has('q') {
+			c.w.WriteString("EN\r\n")
+		}
+		return
+	}
+	if flags.has('v') {
+		c.w.WriteString("VA " + strconv.Itoa(view.Len()) + flags.returned(key, view) + "\r\n")
+		c.w.Write(view.bytes())
+		c.w.WriteString("\r\n")
+		return
+	}
+	c.w.WriteString("HD" + flags.returned(key, view) + "\r\n")
+}
+
+// execMetaSet runs ms <key> <datalen> <flags>*. F<flags> sets the client
+// flags, T<exptime> the expiry and q leaves success unanswered. Only the
+// set mode is supported.
+func (c *memcacheConn) execMetaSet(args []string) error {
+	if len(args) < 2 {
+		return errMemcacheClient
+	}
+	size, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errMemcacheClient
+	}
+	data, err := c.readData(args[0], size)
+	if err == errMemcacheTooLarge {
+		c.w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	key, flags := args[0], metaFlags(args[2:])
+	if err := flags.check("FkMOqT"); err != nil {
+		c.w.WriteString("CLIENT_ERROR " + err.Error() + "\r\n")
+		return nil
+	}
+	if mode, ok := flags.token('M'); ok && mode != "S" && mode != "s" {
+		c.w.WriteString("CLIENT_ERROR invalid mode for ms\r\n")
+		return nil
+	}
+	var clientFlags uint64
+	var exptime int64
+	var err1, err2 error
+	if token, ok := flags.token('F'); ok {
+		clientFlags, err1 = strconv.ParseUint(token, 10, 32)
+	}
+	if token, ok := flags.token('T'); ok {
+		exptime, err2 = strconv.ParseInt(token, 10, 64)
+	}
+	if err1 != nil || err2 != nil {
+		c.w.WriteString("CLIENT_ERROR bad token in command line format\r\n")
+		return nil
+	}
+
+	if err := c.store(key, data, uint32(clientFlags), exptime); err != nil {
+		c.w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+		return nil
+	}
+	if !flags.has('q') {
+		c.w.WriteString("HD" + flags.returned(key, nil) + "\r\n")
+	}
+	return nil
+}
+
+// execMetaDelete runs md <key> <flags>*. q leaves the answer out.
+func (c *memcacheConn) execMetaDelete(args []string) {
+	if len(args) < 1 {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	key, flags := args[0], metaFlags(args[1:])
+	if err := flags.check("kOq"); err != nil {
+		c.w.WriteString("CLIENT_ERROR " + err.Error() + "\r\n")
+		return
+	}
+	deleted := c.remove(key)
+	switch {
+	case flags.has('q'):
+	case deleted:
+		c.w.WriteString("HD" + flags.returned(key, nil) + "\r\n")
+	default:
+		c.w.WriteString("NF" + flags.returned(key, nil) + "\r\n")
+	}
+}
+
+// execStats answers the totals of all groups in the stats memcached
+// clients know.
+func (c *memcacheConn) execStats() {
+	var items, gets, hits int64
+	for _, name := range ListGroups() {
+		if g := GetGroup(name); g != nil {
+			st := g.Stats()
+			items += int64(st.Items)
+			gets += st.Gets
+			hits += st.CacheHits
+		}
+	}
+	for _, stat := range []struct {
+		name  string
+		value int64
+	}{
+		{"pid", int64(os.Getpid())},
+		{"curr_items", items},
+		{"bytes", MemoryUsage()},
+		{"limit_maxbytes", MemoryBudget()},
+		{"cmd_get", gets},
+		{"get_hits", hits},
+		{"get_misses", gets - hits},
+	} {
+		c.w.WriteString("STAT " + stat.name + " " + strconv.FormatInt(stat.value, 10) + "\r\n")
+	}
+	c.w.WriteString("STAT version gocache\r\nEND\r\n")
+}
@@ -0,0 +1,176 @@
+package gocache
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memcacheClient sends raw commands and reads the lines of the answers.
+type memcacheClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startMemcacheServer(t *testing.T, defaultGroup string) *memcacheClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewMemcacheServer(defaultGroup)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &memcacheClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends cmd and reads lines lines of answer, joined with "|".
+func (c *memcacheClient) do(t *testing.T, cmd string, lines int) string {
+	t.Helper()
+	if _, err := c.conn.Write([]byte(cmd)); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := 0; i < lines; i++ {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%q: %v after %q", cmd, err, got)
+		}
+		got = append(got, strings.TrimSuffix(line, "\r\n"))
+	}
+	return strings.Join(got, "|")
+}
+
+func TestMemcache(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]string
+	)
+	g := NewGroup("memcache", 0, BatchGetterFunc(func(keys []string) []BatchResult {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+		res := make([]BatchResult, len(keys))
+		for i, key := range keys {
+			res[i] = BatchResult{Value: []byte("v-" + key), Meta: Meta{Version: 9}}
+			if key == "bad" {
+				res[i].Err = errors.New("no such key")
+			}
+		}
+		return res
+	}))
+	defer g.Close()
+
+	c := startMemcacheServer(t, "memcache")
+	for _, tt := range []struct {
+		cmd   string
+		lines int
+		want  string
+	}{
+		{"get k1 k2 bad memcache:k3\r\n", 7, "VALUE k1 0 4|v-k1|VALUE k2 0 4|v-k2|VALUE memcache:k3 0 4|v-k3|END"},
+		{"gets k1\r\n", 3, "VALUE k1 0 4 9|v-k1|END"},
+		{"set k4 42 0 5\r\nhello\r\n", 1, "STORED"},
+		{"get k4\r\n", 3, "VALUE k4 42 5|hello|END"},
+		{"set k4 1 0 2 noreply\r\nhi\r\nget k4\r\n", 3, "VALUE k4 1 2|hi|END"},
+		{"add k5 0 0 1\r\nx\r\n", 1, "SERVER_ERROR add is not supported"},
+		{"touch k4 100\r\n", 1, "TOUCHED"},
+		{"touch k9 100\r\n", 1, "NOT_FOUND"},
+		{"mg k4 v f t k Oabc\r\n", 2, "VA 2 f1 t100 kk4 Oabc|hi"},
+		{"mg k4 s c\r\n", 1, "HD s2 c0"},
+		{"ms k6 3 F7 T-1\r\nnew\r\nmg bad q\r\nmn\r\n", 2, "HD|MN"},
+		{"ms k6 3 F7 q\r\nnew\r\nmg k6 v f\r\n", 2, "VA 3 f7|new"},
+		{"ms k6 1 MA\r\nx\r\n", 1, "CLIENT_ERROR invalid mode for ms"},
+		{"md k6 q\r\nmd k6 kk6\r\n", 1, "NF kk6"},
+		{"delete k4\r\n", 1, "DELETED"},
+		{"delete k4\r\n", 1, "NOT_FOUND"},
+		{"mg k1 x\r\n", 1, `CLIENT_ERROR invalid flag "x"`},
+		{"incr k1 1\r\n", 1, "ERROR"},
+		{"version\r\n", 1, "VERSION gocache"},
+	} {
+		if got := c.do(t, tt.cmd, tt.lines); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.cmd, tt.want, got)
+		}
+	}
+
+	// the first get loaded its three keys in one batch, bad included
+	if len(batches) == 0 || strings.Join(batches[0], ",") != "k1,k2,bad,k3" {
+		t.Fatalf("expected the first get to be one batch, got %v", batches)
+	}
+
+	// gat touches what it gets
+	if got := c.do(t, "gat 100 k1\r\n", 3); got != "VALUE k1 0 4|v-k1|END" {
+		t.Fatalf("unexpected gat answer %q", got)
+	}
+	if view, ok := g.Lookup("k1"); !ok || view.TTL() <= 99*time.Second {
+		t.Fatalf("expected gat to set a ttl on k1")
+	}
+
+	if got := c.do(t, "stats\r\n", 9); !strings.HasSuffix(got, "STAT version gocache|END") || !strings.Contains(got, "STAT cmd_get ") {
+		t.Fatalf("unexpected stats %q", got)
+	}
+
+	// a bad data chunk closes the connection
+	if got := c.do(t, "set k7 0 0 1\r\nxyz\r\n", 1); got != "CLIENT_ERROR bad data chunk" {
+		t.Fatalf("expected a bad data chunk, got %q", got)
+	}
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatalf("expected the connection to be closed")
+	}
+}
+
+func TestMemcacheTTL(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	for _, tt := range []struct {
+		exptime int64
+		ttl     time.Duration
+		expired bool
+	}{
+		{0, 0, false},
+		{-1, 0, true},
+		{60, time.Minute, false},
+		{now.Unix() + 120, 2 * time.Minute, false},
+		{now.Unix() - 1, -time.Second, true},
+	} {
+		if ttl, expired := memcacheTTL(tt.exptime); ttl != tt.ttl || expired != tt.expired {
+			t.Errorf("memcacheTTL(%d) = %v, %v; want %v, %v", tt.exptime, ttl, expired, tt.ttl, tt.expired)
+		}
+	}
+}
+
+// TestMemcacheTooLarge tests that a value larger than its group may hold is
+// skipped and refused, leaving the connection usable.
+func TestMemcacheTooLarge(t *testing.T) {
+	g := NewGroup("memcache-small", 1<<10, GetterFunc(func(key string) ([]byte, error) {
+		return nil, errors.New("no such key")
+	}))
+	defer g.Close()
+
+	c := startMemcacheServer(t, "memcache-small")
+	big := strings.Repeat("x", 1<<10+1)
+	for _, tt := range []struct {
+		cmd   string
+		lines int
+		want  string
+	}{
+		{"set k1 0 0 1025\r\n" + big + "\r\n", 1, "SERVER_ERROR object too large for cache"},
+		{"ms k1 1025\r\n" + big + "\r\n", 1, "SERVER_ERROR object too large for cache"},
+		{"set k1 0 0 2\r\nok\r\n", 1, "STORED"},
+		{"get k1\r\n", 3, "VALUE k1 0 2|ok|END"},
+	} {
+		if got := c.do(t, tt.cmd, tt.lines); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.cmd, tt.want, got)
+		}
+	}
+}
@@ -8,8 +8,21 @@ type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
 }
 
+// FallbackPicker is implemented by a PeerPicker that can name the peer
+// standing in for the owner of a key when the owner cannot be reached.
+// Every node must pick the same peer, so that the key is still loaded by a
+// single node. ok is false if the local node stands in for the owner.
+type FallbackPicker interface {
+	PickFallback(key string) (peer PeerGetter, ok bool)
+}
+
 // PeerGetter is the interface that must be implemented by a peer.
 type PeerGetter interface {
 	// Get returns the value form the group.
 	Get(in *pb.Request, out *pb.Response) error
 }
+
+// PeerScanner is implemented by peers that can enumerate the keys they cache.
+type PeerScanner interface {
+	Scan(in *pb.ScanRequest, out *pb.ScanResponse) error
+}
@@ -0,0 +1,84 @@
+package gocache
+
+import (
+	"errors"
+	"gocache/singleflight"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrNoPeers is returned by a Proxy that has no peer to forward to.
+var ErrNoPeers = errors.New("gocache: no peers")
+
+// Proxy forwards gets to the peer that owns each key, knowing the ring but
+// holding no cache of its own, so that a public API tier can be scaled
+// apart from the cache tier.
+type Proxy struct {
+	pool *HTTPPool
+	// use singleflight.Group so that each key is only forwarded once
+	loader singleflight.Group
+}
+
+// NewProxy creates a Proxy routing with the ring of pool. The proxy is
+// not a peer itself, so pool's self address should not be in its peers.
+func NewProxy(pool *HTTPPool) *Proxy {
+	return &Proxy{pool: pool}
+}
+
+// Pool returns the peer pool the proxy routes with.
+func (p *Proxy) Pool() *HTTPPool {
+	return p.pool
+}
+
+// Get returns the value of key in group from the peer that owns it, or
+// from the peer standing in for the owner if the owner cannot be reached.
+// Like Group.Get, it returns a LoadingError or an OverloadedError if the
+// peers ask to be retried later.
+func (p *Proxy) Get(group, key string) (ByteView, error) {
+	if group == "" || key == "" {
+		return ByteView{}, errors.New("group and key are required")
+	}
+
+	view, err, _ := p.loader.Do(group+"\x00"+key, func() (interface{}, error) {
+		peer, ok := p.pool.PickPeer(key)
+		if !ok {
+			return nil, ErrNoPeers
+		}
+		view, err := fetchFromPeer(peer, group, key, false)
+		if peerAnswered(err) {
+			return view, err
+		}
+		log.Println("[GoCache] Failed to get from peer", err)
+
+		if peer, ok = p.pool.PickFallback(key); !ok {
+			return nil, err
+		}
+		return fetchFromPeer(peer, group, key, true)
+	})
+	if err != nil {
+		return ByteView{}, err
+	}
+	return view.(ByteView).decompress()
+}
+
+// ServeHTTP serves the routes a manager uses to check on the proxy and to
+// push the list of peers to it.
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodGet,
+		r.URL.Path == "/set-peers" && r.Method == http.MethodPost:
+		// the pool authenticates membership updates, see SetAuthenticator
+		p.pool.ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// LoadRouters mounts the proxy on a gin router, see ServeHTTP.
+func (p *Proxy) LoadRouters(router *gin.Engine) {
+	h := gin.WrapH(p)
+	router.GET("/", h)
+	router.POST("/set-peers", h)
+}
@@ -0,0 +1,110 @@
+package gocache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestProxy tests that a proxy forwards gets to the owner, and to the
+// fallback peer when the owner is down.
+func TestProxy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g := NewGroup("proxy", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	defer g.Close()
+	g.SetCompression(Compression{Algorithm: Gzip})
+
+	r := gin.New()
+	NewHTTPPool("").LoadRouters(r)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	proxy := NewProxy(NewHTTPPool("http://proxy"))
+	if _, err := proxy.Get("proxy", "k1"); err != ErrNoPeers {
+		t.Fatalf("expected ErrNoPeers, got %v", err)
+	}
+
+	proxy.Pool().Set(srv.URL)
+	if view, err := proxy.Get("proxy", "k1"); err != nil || view.String() != "value of k1" {
+		t.Fatalf("expected the owner's value, got %q, %v", view.String(), err)
+	}
+	if n := g.Stats().ServerRequests; n != 1 {
+		t.Fatalf("expected 1 request at the owner, got %d", n)
+	}
+
+	// find a key owned by a node that is down
+	dead := "http://127.0.0.1:1"
+	proxy.Pool().Set(dead, srv.URL)
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := "k" + strconv.Itoa(i); proxy.Pool().Owner(k) == dead {
+			key = k
+		}
+	}
+	if view, err := proxy.Get("proxy", key); err != nil || view.String() != "value of "+key {
+		t.Fatalf("expected the fallback's value, got %q, %v", view.String(), err)
+	}
+	if _, ok := g.Lookup(key); !ok {
+		t.Fatalf("expected the fallback peer to cache %s", key)
+	}
+}
+
+// TestProxyLoadError tests that a getter error at the owner is returned
+// without asking the fallback peer to load the key again.
+func TestProxyLoadError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var loads atomic.Int32
+	g := NewGroup("proxy-error", 0, GetterFunc(func(key string) ([]byte, error) {
+		loads.Add(1)
+		return nil, errors.New("not found")
+	}))
+	defer g.Close()
+
+	r := gin.New()
+	NewHTTPPool("").LoadRouters(r)
+	owner := httptest.NewServer(r)
+	defer owner.Close()
+	fallback := httptest.NewServer(r)
+	defer fallback.Close()
+
+	proxy := NewProxy(NewHTTPPool("http://proxy"))
+	proxy.Pool().Set(owner.URL, fallback.URL)
+	if _, err := proxy.Get("proxy-error", "k1"); err == nil {
+		t.Fatalf("expected the getter's error")
+	}
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("expected a single load, got %d", n)
+	}
+}
+
+// TestProxyHandler tests that a proxy serves its routes as a plain
+// http.Handler.
+func TestProxyHandler(t *testing.T) {
+	proxy := NewProxy(NewHTTPPool("http://proxy"))
+	for _, tt := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"GET", "/", "", http.StatusOK},
+		{"POST", "/set-peers", `["http://a", "http://b"]`, http.StatusOK},
+		{"GET", "/_gocache/proxy/k1", "", http.StatusNotFound},
+		{"GET", "/set-peers", "", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.code, w.Code)
+		}
+	}
+	if peers := proxy.Pool().Peers(); len(peers) != 2 {
+		t.Fatalf("expected the pushed peers, got %v", peers)
+	}
+}
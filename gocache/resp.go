@@ -0,0 +1,435 @@
+package gocache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxRESPArgs = 4096
+	maxRESPBulk = maxValueBytes
+	// a command carries one value at most, besides its name and keys
+	maxRESPCommand = maxRESPBulk + 1<<20
+)
+
+var errRESPProtocol = errors.New("Protocol error")
+
+// RESPServer serves the groups of this node over the Redis protocol
+// (RESP), so that redis clients and redis-cli can read from gocache.
+// GET, MGET, SET, DEL, EXISTS, TTL, PTTL, PING, SELECT and INFO are
+// supported.
+//
+// A key is looked up in the group the connection selected with
+// SELECT name. Until it selects one, a key like "scores:Tom" names its
+// group before the separator, and keys without one go to DefaultGroup.
+// SET, DEL, EXISTS and TTL act on the local cache only, like Group.Set,
+// Group.Remove and Group.Lookup.
+type RESPServer struct {
+	DefaultGroup string // group of keys that name none, may be empty
+	Separator    string // separates the group from the key, ":" if empty
+
+	srv tcpServer
+}
+
+// NewRESPServer creates a RESP server serving keys without a group prefix
+// from defaultGroup.
+func NewRESPServer(defaultGroup string) *RESPServer {
+	return &RESPServer{DefaultGroup: defaultGroup}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+// until Close.
+func (s *RESPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves connections accepted on l until l fails or the server is
+// closed, in which case it returns ErrServerClosed.
+func (s *RESPServer) Serve(l net.Listener) error {
+	return s.srv.serve(l, s.serveConn)
+}
+
+// Close stops the server, closing its listeners and connections.
+func (s *RESPServer) Close() error {
+	return s.srv.close()
+}
+
+// respConn is the state of a client connection.
+type respConn struct {
+	s     *RESPServer
+	r     *bufio.Reader
+	w     *bufio.Writer
+	group string // selected with SELECT
+	quit  bool
+}
+
+func (s *RESPServer) serveConn(conn net.Conn) {
+	c := &respConn{
+		s: s,
+		r: bufio.NewReader(conn),
+		w: bufio.NewWriter(conn),
+	}
+	for !c.quit {
+		args, err := readRESPCommand(c.r)
+		if err != nil {
+			if errors.Is(err, errRESPProtocol) {
+				c.writeError(err.Error())
+				c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		c.exec(args)
+		// pipelined commands are answered together
+		if c.r.Buffered() == 0 || c.quit {
+			if c.w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// readRESPCommand reads a command, either an array of bulk strings as
+// sent by clients or an inline command as typed into telnet.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxRESPArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errRESPProtocol)
+	}
+	var (
+		args  []string
+		total int
+	)
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errRESPProtocol, line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxRESPBulk {
+			return nil, fmt.Errorf("%w: invalid bulk length", errRESPProtocol)
+		}
+		if total += size; total > maxRESPCommand {
+			return nil, fmt.Errorf("%w: too big request", errRESPProtocol)
+		}
+		buf, ok, err := readBlock(r, size)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: bulk string not terminated", errRESPProtocol)
+		}
+		args = append(args, string(buf))
+	}
+	return args, nil
+}
+
+// readRESPLine reads a line without its CRLF. Lines must fit in the
+// reader's buffer.
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("%w: too big request", errRESPProtocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (c *respConn) writeString(s string) {
+	c.w.WriteString("+" + s + "\r\n")
+}
+
+// writeError answers an error. Line breaks, e.g. from a client's command
+// name or a getter's error, would end the reply early, so like redis it
+// turns them into spaces.
+func (c *respConn) writeError(msg string) {
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	c.w.WriteString("-ERR " + msg + "\r\n")
+}
+
+func (c *respConn) writeInt(n int64) {
+	c.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (c *respConn) writeBulk(b []byte) {
+	c.w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	c.w.Write(b)
+	c.w.WriteString("\r\n")
+}
+
+func (c *respConn) writeNil() {
+	c.w.WriteString("$-1\r\n")
+}
+
+func (c *respConn) writeArray(n int) {
+	c.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// respArity is the number of arguments of each command, including its
+// name. Negative values are minimums.
+var respArity = map[string]int{
+	"ping":    -1,
+	"echo":    2,
+	"quit":    1,
+	"command": -1,
+	"select":  2,
+	"get":     2,
+	"mget":    -2,
+	"set":     -3,
+	"del":     -2,
+	"exists":  -2,
+	"ttl":     2,
+	"pttl":    2,
+	"info":    -1,
+}
+
+func (c *respConn) exec(args []string) {
+	name := strings.ToLower(args[0])
+	arity, ok := respArity[name]
+	if !ok {
+		c.writeError(fmt.Sprintf("unknown command '%s'", args[0]))
+		return
+	}
+	if (arity > 0 && len(args) != arity) || (arity < 0 && len(args) < -arity) {
+		c.writeError(fmt.Sprintf("wrong number of arguments for '%s' command", name))
+		return
+	}
+
+	switch name {
+	case "ping":
+		if len(args) > 1 {
+			c.writeBulk([]byte(args[1]))
+		} else {
+			c.writeString("PONG")
+		}
+	case "echo":
+		c.writeBulk([]byte(args[1]))
+	case "quit":
+		c.writeString("OK")
+		c.quit = true
+	case "command":
+		// redis-cli asks for the command table on start
+		c.writeArray(0)
+	case "select":
+		c.execSelect(args[1])
+	case "get":
+		c.execGet(args[1])
+	case "mget":
+		c.execMGet(args[1:])
+	case "set":
+		c.execSet(args[1:])
+	case "del":
+		c.execDel(args[1:])
+	case "exists":
+		c.execExists(args[1:])
+	case "ttl":
+		c.execTTL(args[1], time.Second)
+	case "pttl":
+		c.execTTL(args[1], time.Millisecond)
+	case "info":
+		c.execInfo()
+	}
+}
+
+// execSelect selects a group by name, or by its index in ListGroups so
+// that clients configured with a database number work too.
+func (c *respConn) execSelect(name string) {
+	if GetGroup(name) == nil {
+		names := ListGroups()
+		i, err := strconv.Atoi(name)
+		if err != nil || i < 0 || i >= len(names) {
+			c.writeError(ErrNoSuchGroup.Error())
+			return
+		}
+		name = names[i]
+	}
+	c.group = name
+	c.writeString("OK")
+}
+
+// resolve returns the group of a client key and the key within it.
+func (c *respConn) resolve(key string) (*Group, string, error) {
+	if c.group != "" {
+		if g := GetGroup(c.group); g != nil {
+			return g, key, nil
+		}
+		return nil, "", ErrNoSuchGroup
+	}
+	return splitGroupKey(key, c.s.Separator, c.s.DefaultGroup)
+}
+
+// execGet answers a key that fails to load nil, as execMGet does.
+func (c *respConn) execGet(key string) {
+	g, key, err := c.resolve(key)
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	view, err := g.Get(key)
+	if err != nil {
+		c.writeNil()
+		return
+	}
+	c.writeBulk(view.bytes())
+}
+
+// execMGet loads the keys of each group together. Keys that fail to load
+// are answered nil, as redis does for missing keys.
+func (c *respConn) execMGet(keys []string) {
+	values := make([][]byte, len(keys))
+	byGroup := make(map[*Group][]int)
+	local := make([]string, len(keys))
+	for i, key := range keys {
+		g, key, err := c.resolve(key)
+		if err != nil {
+			continue
+		}
+		local[i] = key
+		byGroup[g] = append(byGroup[g], i)
+	}
+	for g, idx := range byGroup {
+		groupKeys := make([]string, len(idx))
+		for j, i := range idx {
+			groupKeys[j] = local[i]
+		}
+		views, errs := g.GetMany(groupKeys)
+		for j, i := range idx {
+			if errs[j] == nil {
+				values[i] = views[j].bytes()
+			}
+		}
+	}
+
+	c.writeArray(len(values))
+	for _, v := range values {
+		if v == nil {
+			c.writeNil()
+		} else {
+			c.writeBulk(v)
+		}
+	}
+}
+
+// execSet stores a value with an optional EX or PX expiry.
+func (c *respConn) execSet(args []string) {
+	var meta Meta
+	for i := 2; i < len(args); i++ {
+		unit := time.Duration(0)
+		switch strings.ToLower(args[i]) {
+		case "ex":
+			unit = time.Second
+		case "px":
+			unit = time.Millisecond
+		default:
+			c.writeError("syntax error")
+			return
+		}
+		if i+1 >= len(args) {
+			c.writeError("syntax error")
+			return
+		}
+		i++
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil || n <= 0 {
+			c.writeError("invalid expire time in 'set' command")
+			return
+		}
+		meta.TTL = time.Duration(n) * unit
+	}
+
+	g, key, err := c.resolve(args[0])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	if err := g.Set(key, []byte(args[1]), meta); err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeString("OK")
+}
+
+func (c *respConn) execDel(keys []string) {
+	var n int64
+	for _, key := range keys {
+		if g, key, err := c.resolve(key); err == nil && g.Remove(key) {
+			n++
+		}
+	}
+	c.writeInt(n)
+}
+
+func (c *respConn) execExists(keys []string) {
+	var n int64
+	for _, key := range keys {
+		if g, key, err := c.resolve(key); err == nil {
+			if _, ok := g.Lookup(key); ok {
+				n++
+			}
+		}
+	}
+	c.writeInt(n)
+}
+
+// execTTL answers the time left in units, -1 for keys without expiry and
+// -2 for keys not in the local cache.
+func (c *respConn) execTTL(key string, unit time.Duration) {
+	g, key, err := c.resolve(key)
+	if err != nil {
+		c.writeInt(-2)
+		return
+	}
+	view, ok := g.Lookup(key)
+	if !ok {
+		c.writeInt(-2)
+		return
+	}
+	ttl := view.TTL()
+	if ttl == 0 {
+		c.writeInt(-1)
+		return
+	}
+	c.writeInt(int64((ttl + unit/2) / unit))
+}
+
+func (c *respConn) execInfo() {
+	var b strings.Builder
+	b.WriteString("# Memory\r\n")
+	fmt.Fprintf(&b, "used_memory:%d\r\n", MemoryUsage())
+	fmt.Fprintf(&b, "maxmemory:%d\r\n", MemoryBudget())
+	b.WriteString("\r\n# Groups\r\n")
+	for _, name := range ListGroups() {
+		g := GetGroup(name)
+		if g == nil {
+			continue
+		}
+		st := g.Stats()
+		fmt.Fprintf(&b, "group_%s:items=%d,bytes=%d,cache_bytes=%d,gets=%d,cache_hits=%d,loads=%d,local_loads=%d,peer_loads=%d\r\n",
+			name, st.Items, st.Bytes, st.CacheBytes, st.Gets, st.CacheHits, st.Loads, st.LocalLoads, st.PeerLoads)
+	}
+	c.writeBulk([]byte(b.String()))
+}
@@ -0,0 +1,206 @@
+package gocache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// respClient is a minimal redis client.
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func startRESPServer(t *testing.T, defaultGroup string) *respClient {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewRESPServer(defaultGroup)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &respClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *respClient) send(args ...string) {
+	fmt.Fprintf(c.conn, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.conn, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// reply reads a reply. Simple strings and errors keep their type prefix,
+// integers are int64, nil bulk strings are nil and arrays are []any.
+func (c *respClient) reply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+', '-':
+		return line, nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.reply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, errors.New("unexpected reply " + line)
+}
+
+func (c *respClient) do(t *testing.T, args ...string) any {
+	t.Helper()
+	c.send(args...)
+	v, err := c.reply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestRESP(t *testing.T) {
+	loads := 0
+	g := NewGroup("resp", 0, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		if key == "bad" {
+			return nil, errors.New("no such key")
+		}
+		return []byte("value of " + key), nil
+	}))
+	defer g.Close()
+	other := NewGroup("resp-other", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("other " + key), nil
+	}))
+	defer other.Close()
+
+	c := startRESPServer(t, "resp")
+	for _, tt := range []struct {
+		args []string
+		want any
+	}{
+		{[]string{"PING"}, "+PONG"},
+		{[]string{"ping", "hi"}, "hi"},
+		{[]string{"GET", "k1"}, "value of k1"},
+		{[]string{"GET", "resp-other:k1"}, "other k1"},
+		{[]string{"GET", "bad"}, nil},
+		{[]string{"MGET", "k1", "bad", "resp-other:k2"}, []any{"value of k1", nil, "other k2"}},
+		{[]string{"EXISTS", "k1", "k2", "resp-other:k2"}, int64(2)},
+		{[]string{"TTL", "k1"}, int64(-1)},
+		{[]string{"TTL", "k2"}, int64(-2)},
+		{[]string{"SET", "k2", "set", "EX", "100"}, "+OK"},
+		{[]string{"GET", "k2"}, "set"},
+		{[]string{"TTL", "k2"}, int64(100)},
+		{[]string{"SET", "k3", "set", "PX", "-1"}, "-ERR invalid expire time in 'set' command"},
+		{[]string{"SET", "k3", "set", "NX"}, "-ERR syntax error"},
+		{[]string{"DEL", "k1", "k2", "k3"}, int64(2)},
+		{[]string{"EXISTS", "k1"}, int64(0)},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command"},
+		{[]string{"FLUSHALL"}, "-ERR unknown command 'FLUSHALL'"},
+		{[]string{"FOO\r\n+OK"}, "-ERR unknown command 'FOO  +OK'"},
+		{[]string{"SELECT", "resp-unknown"}, "-ERR " + ErrNoSuchGroup.Error()},
+		{[]string{"SELECT", "resp-other"}, "+OK"},
+		{[]string{"GET", "resp:k1"}, "other resp:k1"},
+	} {
+		if got := c.do(t, tt.args...); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%v: expected %v, got %v", tt.args, tt.want, got)
+		}
+	}
+	if loads != 3 {
+		t.Errorf("expected 3 loads, got %d", loads)
+	}
+
+	info, ok := c.do(t, "INFO").(string)
+	if !ok || !strings.Contains(info, "group_resp:items=") {
+		t.Errorf("expected group stats in INFO, got %v", info)
+	}
+}
+
+// TestRESPPipeline tests that pipelined and inline commands are answered
+// in order.
+func TestRESPPipeline(t *testing.T) {
+	g := NewGroup("resp-pipeline", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer g.Close()
+
+	c := startRESPServer(t, "resp-pipeline")
+	for i := 0; i < 100; i++ {
+		c.send("GET", strconv.Itoa(i))
+	}
+	c.conn.Write([]byte("PING\r\n"))
+	for i := 0; i < 100; i++ {
+		if v, err := c.reply(); err != nil || v != strconv.Itoa(i) {
+			t.Fatalf("expected %d, got %v, %v", i, v, err)
+		}
+	}
+	if v, err := c.reply(); err != nil || v != "+PONG" {
+		t.Fatalf("expected the inline PING to be answered, got %v, %v", v, err)
+	}
+
+	c.conn.Write([]byte("*1\r\n+GET\r\n"))
+	if v, _ := c.reply(); !strings.HasPrefix(fmt.Sprint(v), "-ERR Protocol error") {
+		t.Fatalf("expected a protocol error, got %v", v)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.r.ReadByte(); err == nil {
+		t.Fatalf("expected the connection to be closed")
+	}
+}
+
+// TestRESPDeclaredLengths tests that lengths a client declares are checked
+// and that memory is only taken as the data arrives.
+func TestRESPDeclaredLengths(t *testing.T) {
+	read := func(s string) error {
+		_, err := readRESPCommand(bufio.NewReader(strings.NewReader(s)))
+		return err
+	}
+	for _, s := range []string{
+		fmt.Sprintf("*%d\r\n", maxRESPArgs+1),
+		fmt.Sprintf("*1\r\n$%d\r\n", maxRESPBulk+1),
+	} {
+		if err := read(s); !errors.Is(err, errRESPProtocol) {
+			t.Errorf("%.20q: expected a protocol error, got %v", s, err)
+		}
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := read(fmt.Sprintf("*%d\r\n$%d\r\nGET\r\n", maxRESPArgs, maxRESPBulk))
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected a truncated command, got %v", err)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Fatalf("expected the declared lengths not to be allocated, got %d bytes", alloc)
+	}
+}
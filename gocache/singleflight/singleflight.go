@@ -1,48 +1,307 @@
 package singleflight
 
-import "sync"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// errGoexit indicates fn called runtime.Goexit.
+var errGoexit = errors.New("runtime.Goexit was called")
+
+// errMissingResult is the error of a key DoMany's fn returned no result for.
+var errMissingResult = errors.New("singleflight: no result for key")
+
+// A panicError is a panic recovered from fn, re-raised in every waiter.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *panicError) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+func (p *panicError) Unwrap() error {
+	err, ok := p.value.(error)
+	if !ok {
+		return nil
+	}
+	return err
+}
+
+func newPanicError(v interface{}) error {
+	stack := debug.Stack()
+
+	// The first line of the stack trace is "goroutine N [status]:", which
+	// would mislead readers about the goroutine the panic is re-raised in.
+	if line := bytes.IndexByte(stack, '\n'); line >= 0 {
+		stack = stack[line+1:]
+	}
+	return &panicError{value: v, stack: stack}
+}
 
 // call is an in-flight or completed Do call
 type call struct {
 	wg  sync.WaitGroup
 	val interface{}
 	err error
+
+	// dups and chans are guarded by Group.mu
+	dups  int
+	chans []chan<- Result
 }
 
+// Group deduplicates concurrent calls made for the same key.
 type Group struct {
 	mu sync.Mutex // protects m
 	m  map[string]*call
 }
 
+// Result holds the results of Do, so they can be passed on a channel.
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool
+}
+
 // Do executes and returns the results of the given function, making sure that
 // only one execution is in-flight for a given key at a time. If a duplicate
 // comes in, the duplicate caller waits for the original to complete and
-// receives the same results.
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+// receives the same results. The return value shared reports whether v was
+// given to multiple callers.
+//
+// If fn panics, the panic is re-raised in every caller waiting on the key.
+// If fn calls runtime.Goexit, the callers waiting on the key exit too.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
 	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 
 	if c, ok := g.m[key]; ok {
+		c.dups++
 		g.mu.Unlock()
-		c.wg.Wait()  // 如果请求正在进行中，则等待
-		return c.val, c.err  // 请求结束，返回结果
+		c.wg.Wait() // 如果请求正在进行中，则等待
+
+		if e, ok := c.err.(*panicError); ok {
+			panic(e)
+		} else if c.err == errGoexit {
+			runtime.Goexit()
+		}
+		return c.val, c.err, true // 请求结束，返回结果
 	}
 
 	c := new(call)
-	c.wg.Add(1)  // 发起请求前加锁
+	c.wg.Add(1) // 发起请求前加锁
 	g.m[key] = c
 	g.mu.Unlock()
 
-	// 执行请求
-	c.val, c.err = fn()
-	c.wg.Done()
+	g.doCall(c, key, fn)
+	return c.val, c.err, c.dups > 0
+}
 
-	// 执行完毕，删除请求
+// DoChan is like Do but returns a channel that will receive the results when
+// they are ready, so the caller can stop waiting, e.g. on a timeout. The
+// channel is not closed. A panic in fn is not recovered into the channel; it
+// crashes the process, as it cannot be re-raised in the waiting goroutines.
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+
+	c := &call{chans: []chan<- Result{ch}}
+	c.wg.Add(1)
+	g.m[key] = c
+	g.mu.Unlock()
+
+	go g.doCall(c, key, fn)
+	return ch
+}
+
+// DoMany is like Do for several keys at once. fn is called once, with the
+// keys that are not in flight yet, and must return a result for each of
+// them in the same order. The other keys wait for the calls already in
+// flight. The results are returned in the order of keys; keys must not
+// repeat.
+//
+// If fn panics, the panic is re-raised in the caller and in every caller
+// waiting on one of its keys with Do or DoMany. Callers waiting with
+// DoChan receive it as an error, and then the process crashes as it does
+// for a panic in DoChan. A runtime.Goexit in fn is handed to DoChan
+// callers as an error too.
+func (g *Group) DoMany(keys []string, fn func(keys []string) []Result) []Result {
+	calls := make([]*call, len(keys))
+	var (
+		own      []string
+		ownCalls []*call
+	)
+
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	for i, key := range keys {
+		if c, ok := g.m[key]; ok {
+			c.dups++
+			calls[i] = c
+			continue
+		}
+		c := new(call)
+		c.wg.Add(1)
+		g.m[key] = c
+		calls[i] = c
+		own = append(own, key)
+		ownCalls = append(ownCalls, c)
+	}
+	g.mu.Unlock()
+
+	if len(own) > 0 {
+		g.doMany(own, ownCalls, fn)
+	}
+
+	results := make([]Result, len(keys))
+	for i, c := range calls {
+		c.wg.Wait()
+		if e, ok := c.err.(*panicError); ok {
+			panic(e)
+		} else if c.err == errGoexit {
+			runtime.Goexit()
+		}
+		results[i] = Result{c.val, c.err, c.dups > 0}
+	}
+	return results
+}
+
+// doMany runs fn for the calls of keys and hands each call its result,
+// like doCall does for one.
+func (g *Group) doMany(keys []string, calls []*call, fn func(keys []string) []Result) {
+	normalReturn := false
+	defer func() {
+		var err error
+		if !normalReturn {
+			// recover returns nil for runtime.Goexit
+			if r := recover(); r != nil {
+				err = newPanicError(r)
+			} else {
+				err = errGoexit
+			}
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		chans := false
+		for i, c := range calls {
+			if err != nil {
+				c.val, c.err = nil, err
+			}
+			c.wg.Done()
+			if g.m[keys[i]] == c {
+				delete(g.m, keys[i])
+			}
+			// DoChan waiters cannot be panicked in, so they get the error
+			for _, ch := range c.chans {
+				ch <- Result{c.val, c.err, c.dups > 0}
+			}
+			chans = chans || len(c.chans) > 0
+		}
+		if e, ok := err.(*panicError); ok {
+			if chans {
+				// as in doCall, crash loudly rather than let the DoChan
+				// callers carry on as if only their load had failed
+				go panic(e)
+				select {} // keep this goroutine around for the crash dump
+			}
+			panic(e)
+		}
+	}()
+
+	results := fn(keys)
+	for i, c := range calls {
+		if i < len(results) {
+			c.val, c.err = results[i].Val, results[i].Err
+		} else {
+			c.err = errMissingResult
+		}
+	}
+	normalReturn = true
+}
+
+// Forget tells the group to forget about key. Later calls to Do for the key
+// call fn rather than waiting for an earlier call to complete.
+func (g *Group) Forget(key string) {
 	g.mu.Lock()
 	delete(g.m, key)
 	g.mu.Unlock()
+}
+
+// doCall runs fn for the call c and hands its results to the waiters,
+// turning a panic or runtime.Goexit in fn into an error they can see.
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	normalReturn := false
+	recovered := false
 
-	return c.val, c.err
-}
\ No newline at end of file
+	// use a double defer to tell a panic from runtime.Goexit
+	defer func() {
+		// the given function invoked runtime.Goexit
+		if !normalReturn && !recovered {
+			c.err = errGoexit
+		}
+
+		// 执行完毕，删除请求
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		c.wg.Done()
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+
+		if e, ok := c.err.(*panicError); ok {
+			if len(c.chans) > 0 {
+				// a goroutine started by DoChan cannot re-raise the panic
+				// anywhere the caller could recover it, so crash loudly
+				go panic(e)
+				select {} // keep this goroutine around for the crash dump
+			}
+			panic(e)
+		} else if c.err == errGoexit {
+			// already exiting, nothing to do
+		} else {
+			for _, ch := range c.chans {
+				ch <- Result{c.val, c.err, c.dups > 0}
+			}
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				// runtime.Goexit is not recoverable, so recover returns nil
+				// for it and a value for a real panic
+				if r := recover(); r != nil {
+					c.err = newPanicError(r)
+				}
+			}
+		}()
+
+		// 执行请求
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
+}
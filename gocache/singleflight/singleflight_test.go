@@ -0,0 +1,255 @@
+package singleflight
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil || shared {
+		t.Fatalf("Do = %v, %v, %v; want bar, nil, false", v, err, shared)
+	}
+}
+
+func TestDoErr(t *testing.T) {
+	var g Group
+	someErr := errors.New("some error")
+	v, err, _ := g.Do("key", func() (interface{}, error) {
+		return nil, someErr
+	})
+	if err != someErr || v != nil {
+		t.Fatalf("Do = %v, %v; want nil, %v", v, err, someErr)
+	}
+}
+
+// TestDoDupSuppress tests that concurrent callers share a single call
+func TestDoDupSuppress(t *testing.T) {
+	var g Group
+	var calls, shared atomic.Int32
+	release := make(chan struct{})
+	const n = 10
+
+	var started, wg sync.WaitGroup
+	started.Add(n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			v, err, s := g.Do("key", func() (interface{}, error) {
+				calls.Add(1)
+				<-release
+				return "bar", nil
+			})
+			if v != "bar" || err != nil {
+				t.Errorf("Do = %v, %v; want bar, nil", v, err)
+			}
+			if s {
+				shared.Add(1)
+			}
+		}()
+	}
+	started.Wait()
+	time.Sleep(10 * time.Millisecond) // let the callers join the call
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("number of calls = %d; want 1", calls.Load())
+	}
+	if shared.Load() != n {
+		t.Fatalf("number of shared results = %d; want %d", shared.Load(), n)
+	}
+}
+
+// TestDoPanic tests that a panic reaches every caller and frees the key
+func TestDoPanic(t *testing.T) {
+	var g Group
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	panicked := make(chan interface{}, 2)
+	do := func(fn func() (interface{}, error)) {
+		defer func() { panicked <- recover() }()
+		g.Do("key", fn)
+	}
+	go do(func() (interface{}, error) {
+		close(entered)
+		<-release
+		panic("boom")
+	})
+	<-entered
+	go do(func() (interface{}, error) {
+		t.Error("duplicate call should not run")
+		return nil, nil
+	})
+	time.Sleep(10 * time.Millisecond) // let the duplicate join the call
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-panicked:
+			if r == nil {
+				t.Fatalf("caller %d did not panic", i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("caller hung after a panic")
+		}
+	}
+
+	v, err, _ := g.Do("key", func() (interface{}, error) { return "bar", nil })
+	if v != "bar" || err != nil {
+		t.Fatalf("Do after panic = %v, %v; want bar, nil", v, err)
+	}
+}
+
+// TestDoGoexit tests that runtime.Goexit in fn does not hang the key
+func TestDoGoexit(t *testing.T) {
+	var g Group
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Do("key", func() (interface{}, error) {
+			runtime.Goexit()
+			return nil, nil
+		})
+		t.Error("Do returned after runtime.Goexit")
+	}()
+	<-done
+
+	v, err, _ := g.Do("key", func() (interface{}, error) { return "bar", nil })
+	if v != "bar" || err != nil {
+		t.Fatalf("Do after Goexit = %v, %v; want bar, nil", v, err)
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	ch1 := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	})
+	ch2 := g.DoChan("key", func() (interface{}, error) {
+		t.Error("duplicate call should not run")
+		return nil, nil
+	})
+
+	select {
+	case <-ch1:
+		t.Fatal("result delivered before the call finished")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+
+	for _, ch := range []<-chan Result{ch1, ch2} {
+		res := <-ch
+		if res.Val != "bar" || res.Err != nil || !res.Shared {
+			t.Fatalf("DoChan = %+v; want shared bar", res)
+		}
+	}
+}
+
+// TestForget tests that a forgotten key starts a new call
+func TestForget(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	first := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+
+	g.Forget("key")
+	v, _, shared := g.Do("key", func() (interface{}, error) { return 2, nil })
+	if v != 2 || shared {
+		t.Fatalf("Do after Forget = %v, shared %v; want 2 from a new call", v, shared)
+	}
+
+	close(release)
+	if res := <-first; res.Val != 1 {
+		t.Fatalf("first call = %v; want 1", res.Val)
+	}
+}
+
+// TestDoMany tests that DoMany calls fn with the keys not in flight and
+// joins the calls of the others
+func TestDoMany(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	inFlight := g.DoChan("b", func() (interface{}, error) {
+		<-release
+		return "b from Do", nil
+	})
+
+	var got []string
+	entered := make(chan struct{})
+	done := make(chan []Result)
+	go func() {
+		done <- g.DoMany([]string{"a", "b", "c"}, func(keys []string) []Result {
+			got = keys
+			close(entered)
+			<-release
+			return []Result{{Val: "a"}, {Err: errors.New("no c")}}
+		})
+	}()
+
+	<-entered
+	joined := g.DoChan("a", func() (interface{}, error) { return "a from Do", nil })
+	close(release)
+	res := <-done
+	<-inFlight
+
+	if len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("fn called with %v; want [a c]", got)
+	}
+	if res[0].Val != "a" || res[1].Val != "b from Do" || !res[1].Shared || res[2].Err == nil {
+		t.Fatalf("DoMany = %+v", res)
+	}
+	if r := <-joined; r.Val != "a" || !r.Shared {
+		t.Fatalf("DoChan(a) = %+v; want the result of DoMany", r)
+	}
+	if _, _, shared := g.Do("a", func() (interface{}, error) { return nil, nil }); shared {
+		t.Fatalf("expected DoMany to free its keys")
+	}
+}
+
+// TestDoManyGoexit tests that runtime.Goexit in fn hands DoChan waiters an
+// error rather than leaving them waiting
+func TestDoManyGoexit(t *testing.T) {
+	var g Group
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.DoMany([]string{"a"}, func(keys []string) []Result {
+			close(entered)
+			<-release
+			runtime.Goexit()
+			return nil
+		})
+		t.Error("DoMany returned after runtime.Goexit")
+	}()
+
+	<-entered
+	joined := g.DoChan("a", func() (interface{}, error) { return "a from Do", nil })
+	close(release)
+	<-done
+
+	select {
+	case r := <-joined:
+		if r.Err != errGoexit {
+			t.Fatalf("DoChan(a) = %+v; want errGoexit", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("DoChan(a) waiter was never answered")
+	}
+}
@@ -0,0 +1,258 @@
+package gocache
+
+import (
+	"encoding/json"
+	"errors"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// A Sink receives data from a Get call.
+//
+// Implementations of Getter that also implement SinkGetter write their
+// value into a Sink, and callers of Group.GetInto pick the Sink that
+// matches the type they want the value in.
+type Sink interface {
+	// SetString sets the value to s.
+	SetString(s string) error
+
+	// SetBytes sets the value to the contents of v.
+	// The caller retains ownership of v.
+	SetBytes(v []byte) error
+
+	// SetProto sets the value to the encoded version of m.
+	// The caller retains ownership of m.
+	SetProto(m proto.Message) error
+}
+
+// viewSetter is implemented by sinks that can take a ByteView directly,
+// sparing the copy SetBytes or SetString would make.
+type viewSetter interface {
+	setView(v ByteView) error
+}
+
+// setSinkView writes a cached view into s.
+func setSinkView(s Sink, v ByteView) error {
+	v, err := v.decompress()
+	if err != nil {
+		return err
+	}
+	if vs, ok := s.(viewSetter); ok {
+		return vs.setView(v)
+	}
+	if v.b != nil {
+		return s.SetBytes(v.b)
+	}
+	return s.SetString(v.s)
+}
+
+// StringSink returns a Sink that populates the provided string pointer.
+func StringSink(sp *string) Sink {
+	return &stringSink{sp: sp}
+}
+
+type stringSink struct {
+	sp *string
+}
+
+func (s *stringSink) setView(v ByteView) error {
+	// no copy when the view is backed by a string
+	*s.sp = v.String()
+	return nil
+}
+
+func (s *stringSink) SetString(v string) error {
+	*s.sp = v
+	return nil
+}
+
+func (s *stringSink) SetBytes(v []byte) error {
+	return s.SetString(string(v))
+}
+
+func (s *stringSink) SetProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	*s.sp = string(b)
+	return nil
+}
+
+// ByteViewSink returns a Sink that populates a ByteView. Values from the
+// cache are handed over without copying and keep their metadata.
+func ByteViewSink(dst *ByteView) Sink {
+	if dst == nil {
+		panic("nil dst")
+	}
+	return &byteViewSink{dst: dst}
+}
+
+type byteViewSink struct {
+	dst *ByteView
+}
+
+func (s *byteViewSink) setView(v ByteView) error {
+	*s.dst = v
+	return nil
+}
+
+func (s *byteViewSink) SetString(v string) error {
+	*s.dst = ByteView{s: v}
+	return nil
+}
+
+func (s *byteViewSink) SetBytes(v []byte) error {
+	*s.dst = ByteView{b: cloneBytes(v)}
+	return nil
+}
+
+func (s *byteViewSink) SetProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// b is freshly allocated, no need to copy it
+	*s.dst = ByteView{b: b}
+	return nil
+}
+
+// AllocatingByteSliceSink returns a Sink that allocates
+// a byte slice to hold the received value and assigns
+// it to *dst. The memory is not retained by gocache.
+func AllocatingByteSliceSink(dst *[]byte) Sink {
+	return &allocBytesSink{dst: dst}
+}
+
+type allocBytesSink struct {
+	dst *[]byte
+}
+
+func (s *allocBytesSink) SetString(v string) error {
+	*s.dst = []byte(v)
+	return nil
+}
+
+func (s *allocBytesSink) SetBytes(v []byte) error {
+	*s.dst = cloneBytes(v)
+	return nil
+}
+
+func (s *allocBytesSink) SetProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	*s.dst = b
+	return nil
+}
+
+// ErrTruncated is returned by a TruncatingByteSliceSink when the value
+// did not fit into the destination slice.
+var ErrTruncated = errors.New("gocache: value truncated")
+
+// TruncatingByteSliceSink returns a Sink that writes up to len(*dst)
+// bytes to *dst into the caller's buffer, without allocating. If more
+// bytes are available, they're silently truncated and ErrTruncated is
+// returned. If fewer bytes are available than len(*dst), *dst is shrunk
+// to fit the number of bytes available.
+func TruncatingByteSliceSink(dst *[]byte) Sink {
+	return &truncBytesSink{dst: dst}
+}
+
+type truncBytesSink struct {
+	dst *[]byte
+}
+
+func (s *truncBytesSink) setView(v ByteView) error {
+	n := v.Copy(*s.dst)
+	return s.truncate(n, v.Len())
+}
+
+func (s *truncBytesSink) SetString(v string) error {
+	n := copy(*s.dst, v)
+	return s.truncate(n, len(v))
+}
+
+func (s *truncBytesSink) SetBytes(v []byte) error {
+	n := copy(*s.dst, v)
+	return s.truncate(n, len(v))
+}
+
+func (s *truncBytesSink) SetProto(m proto.Message) error {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.SetBytes(b)
+}
+
+func (s *truncBytesSink) truncate(n, total int) error {
+	*s.dst = (*s.dst)[:n]
+	if n < total {
+		return ErrTruncated
+	}
+	return nil
+}
+
+// ProtoSink returns a sink that unmarshals binary proto values into m.
+func ProtoSink(m proto.Message) Sink {
+	return &protoSink{dst: m}
+}
+
+type protoSink struct {
+	dst proto.Message
+}
+
+func (s *protoSink) setView(v ByteView) error {
+	// decode straight from the cached bytes
+	return proto.Unmarshal(v.bytes(), s.dst)
+}
+
+func (s *protoSink) SetString(v string) error {
+	return proto.Unmarshal([]byte(v), s.dst)
+}
+
+func (s *protoSink) SetBytes(v []byte) error {
+	return proto.Unmarshal(v, s.dst)
+}
+
+func (s *protoSink) SetProto(m proto.Message) error {
+	// round-trip through bytes so that m may be of a different type
+	b, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, s.dst)
+}
+
+// JSONSink returns a sink that decodes JSON values into v,
+// which must be a pointer as with json.Unmarshal.
+func JSONSink(v interface{}) Sink {
+	return &jsonSink{dst: v}
+}
+
+type jsonSink struct {
+	dst interface{}
+}
+
+func (s *jsonSink) setView(v ByteView) error {
+	return json.Unmarshal(v.bytes(), s.dst)
+}
+
+func (s *jsonSink) SetString(v string) error {
+	return json.Unmarshal([]byte(v), s.dst)
+}
+
+func (s *jsonSink) SetBytes(v []byte) error {
+	return json.Unmarshal(v, s.dst)
+}
+
+func (s *jsonSink) SetProto(m proto.Message) error {
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, s.dst)
+}
@@ -0,0 +1,73 @@
+package gocache
+
+import (
+	"testing"
+
+	pb "gocache/cachepb"
+)
+
+// TestSinkGetter tests that a SinkGetter's value reaches every kind of sink.
+func TestSinkGetter(t *testing.T) {
+	g := NewGroup("sinks", 2<<10, SinkGetterFunc(
+		func(key string, dest Sink) (Meta, error) {
+			switch key {
+			case "json":
+				return Meta{ContentType: "application/json"}, dest.SetString(`{"name":"Tom","score":630}`)
+			case "proto":
+				return Meta{}, dest.SetProto(&pb.Response{Value: []byte("630"), Version: 2})
+			}
+			return Meta{}, dest.SetBytes([]byte(db[key]))
+		}))
+	defer g.Close()
+
+	var s string
+	if err := g.GetInto("Tom", StringSink(&s)); err != nil || s != "630" {
+		t.Fatalf("StringSink got %q, %v", s, err)
+	}
+
+	var b []byte
+	if err := g.GetInto("Jack", AllocatingByteSliceSink(&b)); err != nil || string(b) != "589" {
+		t.Fatalf("AllocatingByteSliceSink got %q, %v", b, err)
+	}
+
+	buf := make([]byte, 2)
+	if err := g.GetInto("Sam", TruncatingByteSliceSink(&buf)); err != ErrTruncated || string(buf) != "56" {
+		t.Fatalf("TruncatingByteSliceSink got %q, %v", buf, err)
+	}
+
+	var v struct {
+		Name  string
+		Score int
+	}
+	if err := g.GetInto("json", JSONSink(&v)); err != nil || v.Name != "Tom" || v.Score != 630 {
+		t.Fatalf("JSONSink got %+v, %v", v, err)
+	}
+
+	res := &pb.Response{}
+	if err := g.GetInto("proto", ProtoSink(res)); err != nil || string(res.Value) != "630" || res.Version != 2 {
+		t.Fatalf("ProtoSink got %v, %v", res, err)
+	}
+
+	var view ByteView
+	if err := g.GetInto("json", ByteViewSink(&view)); err != nil || view.Meta().ContentType != "application/json" {
+		t.Fatalf("ByteViewSink lost metadata: %+v, %v", view.Meta(), err)
+	}
+}
+
+// TestSinkGetterSingleCopy tests that bytes handed to a sink are copied
+// exactly once, so the getter may reuse its buffer.
+func TestSinkGetterSingleCopy(t *testing.T) {
+	buf := []byte("630")
+	g := NewGroup("sinks-copy", 2<<10, SinkGetterFunc(
+		func(key string, dest Sink) (Meta, error) {
+			return Meta{}, dest.SetBytes(buf)
+		}))
+	defer g.Close()
+	if _, err := g.Get("Tom"); err != nil {
+		t.Fatal(err)
+	}
+	copy(buf, "xxx")
+	if view, _ := g.Get("Tom"); view.String() != "630" {
+		t.Fatalf("cached value aliases the getter's buffer: %q", view.String())
+	}
+}
@@ -0,0 +1,71 @@
+package gocache
+
+import "sync/atomic"
+
+// Stats are per-group statistics.
+type Stats struct {
+	Gets           atomic.Int64 // any Get request, including from peers
+	CacheHits      atomic.Int64 // gets answered from the local cache
+	PeerLoads      atomic.Int64 // loads a peer answered with a value, cached there or loaded by it
+	PeerErrors     atomic.Int64
+	PeerLoading    atomic.Int64 // peer asked us to retry, the key was still loading
+	Loads          atomic.Int64 // (gets - cacheHits)
+	LoadsDeduped   atomic.Int64 // after singleflight
+	LocalLoads     atomic.Int64 // total good local loads
+	LocalLoadErrs  atomic.Int64 // total bad local loads
+	StaleFills     atomic.Int64 // local loads not cached, the key was invalidated meanwhile
+	LoadsQueued    atomic.Int64 // local loads that waited for the load limits
+	LoadsShed      atomic.Int64 // local loads refused by the load limits
+	LoadsInFlight  atomic.Int64 // getter calls running now
+	ServerShed     atomic.Int64 // gets from peers answered 503, see LoadLimits
+	ServerRequests atomic.Int64 // gets that came over the network from peers
+}
+
+// GroupStats is a snapshot of a group's statistics and cache usage.
+type GroupStats struct {
+	Name           string `json:"name"`
+	Gets           int64  `json:"gets"`
+	CacheHits      int64  `json:"cache_hits"`
+	PeerLoads      int64  `json:"peer_loads"`
+	PeerErrors     int64  `json:"peer_errors"`
+	PeerLoading    int64  `json:"peer_loading"`
+	Loads          int64  `json:"loads"`
+	LoadsDeduped   int64  `json:"loads_deduped"`
+	LocalLoads     int64  `json:"local_loads"`
+	LocalLoadErrs  int64  `json:"local_load_errs"`
+	StaleFills     int64  `json:"stale_fills"`
+	LoadsQueued    int64  `json:"loads_queued"`
+	LoadsShed      int64  `json:"loads_shed"`
+	LoadsInFlight  int64  `json:"loads_in_flight"`
+	ServerShed     int64  `json:"server_shed"`
+	ServerRequests int64  `json:"server_requests"`
+	Items          int    `json:"items"`
+	Bytes          int64  `json:"bytes"`
+	CacheBytes     int64  `json:"cache_bytes"`
+}
+
+// Stats returns a snapshot of the group's statistics.
+func (g *Group) Stats() GroupStats {
+	items, cacheBytes := g.mainCache.stats()
+	return GroupStats{
+		Name:           g.name,
+		Gets:           g.stats.Gets.Load(),
+		CacheHits:      g.stats.CacheHits.Load(),
+		PeerLoads:      g.stats.PeerLoads.Load(),
+		PeerErrors:     g.stats.PeerErrors.Load(),
+		PeerLoading:    g.stats.PeerLoading.Load(),
+		Loads:          g.stats.Loads.Load(),
+		LoadsDeduped:   g.stats.LoadsDeduped.Load(),
+		LocalLoads:     g.stats.LocalLoads.Load(),
+		LocalLoadErrs:  g.stats.LocalLoadErrs.Load(),
+		StaleFills:     g.stats.StaleFills.Load(),
+		LoadsQueued:    g.stats.LoadsQueued.Load(),
+		LoadsShed:      g.stats.LoadsShed.Load(),
+		LoadsInFlight:  g.stats.LoadsInFlight.Load(),
+		ServerShed:     g.stats.ServerShed.Load(),
+		ServerRequests: g.stats.ServerRequests.Load(),
+		Items:          items,
+		Bytes:          g.mainCache.bytes(),
+		CacheBytes:     cacheBytes,
+	}
+}
@@ -0,0 +1,506 @@
+package gocache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	pb "gocache/cachepb"
+	"gocache/consistenthash"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// The TCP peer protocol exchanges length-prefixed frames:
+//
+//	uint32 length of the rest of the frame, big endian
+//	uint64 request id, chosen by the client
+//	uint8  frame kind
+//	       payload, a cachepb message or an error
+//
+// A connection carries any number of requests at once. The server answers
+// each request as soon as it is done, with the id of the request, so a
+// slow load does not hold up the requests behind it.
+const (
+	tcpFrameHeader = 4 + 8 + 1
+	maxTCPFrame    = 256 << 20
+	tcpDialTimeout = 5 * time.Second
+	tcpKeepAlive   = 15 * time.Second
+	// defaultTCPTimeout bounds a request to a peer, see TCPPool.SetTimeout.
+	defaultTCPTimeout = 30 * time.Second
+)
+
+var (
+	errTCPFrameTooLarge = errors.New("gocache: frame too large")
+	errTCPTimeout       = errors.New("gocache: peer did not answer in time")
+)
+
+// frame kinds
+const (
+	tcpGet        byte = iota + 1 // pb.Request
+	tcpScan                       // pb.ScanRequest
+	tcpResponse                   // pb.Response or pb.ScanResponse
+	tcpError                      // error message
+	tcpOverloaded                 // uint64 milliseconds to retry after
+)
+
+// TCPPool implements PeerPicker for a pool of peers speaking a binary
+// protocol over TCP, which spares the HTTP overhead on every peer hop. It
+// is used in place of an HTTPPool: peers are "host:port" addresses and
+// each node serves the protocol with ListenAndServe or Serve.
+type TCPPool struct {
+	self       string              // e.g. "10.0.0.2:9001"
+	mu         sync.Mutex          // guards peers, peerList and tcpGetters
+	peers      *consistenthash.Map // a map of peers
+	peerList   []string            // peers as passed to Set
+	tcpGetters map[string]*tcpGetter
+	timeout    time.Duration // see SetTimeout
+	srv        tcpServer
+}
+
+// NewTCPPool initializes a TCP pool of peers.
+func NewTCPPool(self string) *TCPPool {
+	return &TCPPool{self: self, timeout: defaultTCPTimeout}
+}
+
+// SetTimeout bounds how long a request waits for a peer's answer, 30s by
+// default. A request that times out fails as if the peer could not be
+// reached, and if the peer has sent nothing since the request, its
+// connection is taken for stalled and redialed by the next request.
+func (p *TCPPool) SetTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.timeout = timeout
+	for _, getter := range p.tcpGetters {
+		getter.timeout.Store(int64(timeout))
+	}
+}
+
+// Set updates the pool's list of peers, closing the connections to the
+// peers that are gone.
+func (p *TCPPool) Set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.peers = consistenthash.New(defaultReplicas, nil)
+	p.peers.Add(peers...)
+	p.peerList = append([]string(nil), peers...)
+
+	getters := make(map[string]*tcpGetter, len(peers))
+	for _, peer := range peers {
+		if getter, ok := p.tcpGetters[peer]; ok {
+			getters[peer] = getter
+		} else {
+			getters[peer] = &tcpGetter{addr: peer}
+			getters[peer].timeout.Store(int64(p.timeout))
+		}
+	}
+	for peer, getter := range p.tcpGetters {
+		if _, ok := getters[peer]; !ok {
+			getter.close()
+		}
+	}
+	p.tcpGetters = getters
+}
+
+// PickPeer picks a peer according to key.
+func (p *TCPPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+
+	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+		return p.tcpGetters[peer], true
+	}
+
+	return nil, false
+}
+
+// PickFallback picks the peer standing in for the owner of key, the next
+// node on the ring, for when the owner cannot be reached.
+func (p *TCPPool) PickFallback(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+
+	if nodes := p.peers.GetN(key, 2); len(nodes) == 2 && nodes[1] != p.self {
+		return p.tcpGetters[nodes[1]], true
+	}
+
+	return nil, false
+}
+
+// Self returns the pool's own address.
+func (p *TCPPool) Self() string {
+	return p.self
+}
+
+// Peers returns the current list of peers.
+func (p *TCPPool) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.peerList...)
+}
+
+// ListenAndServe serves the peer protocol on the pool's own address until
+// Close.
+func (p *TCPPool) ListenAndServe() error {
+	l, err := net.Listen("tcp", p.self)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve serves the peer protocol on connections accepted on l until l
+// fails or the pool is closed, in which case it returns ErrServerClosed.
+func (p *TCPPool) Serve(l net.Listener) error {
+	return p.srv.serve(l, serveTCPConn)
+}
+
+// Close stops serving and closes the connections to the peers.
+func (p *TCPPool) Close() error {
+	p.mu.Lock()
+	for _, getter := range p.tcpGetters {
+		getter.close()
+	}
+	p.mu.Unlock()
+	return p.srv.close()
+}
+
+// check that TCPPool implements PeerPicker and FallbackPicker
+var (
+	_ PeerPicker     = (*TCPPool)(nil)
+	_ FallbackPicker = (*TCPPool)(nil)
+)
+
+// frameWriter writes frames from many goroutines. Frames written while
+// another is being written are flushed together.
+type frameWriter struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	writers atomic.Int32 // goroutines writing or waiting to
+}
+
+func (fw *frameWriter) write(id uint64, kind byte, payload []byte) error {
+	if len(payload) > maxTCPFrame-8-1 {
+		return errTCPFrameTooLarge
+	}
+	fw.writers.Add(1)
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	var hdr [tcpFrameHeader]byte
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(8+1+len(payload)))
+	binary.BigEndian.PutUint64(hdr[4:12], id)
+	hdr[12] = kind
+	_, err := fw.w.Write(hdr[:])
+	if err == nil {
+		_, err = fw.w.Write(payload)
+	}
+	// the last writer flushes for those before it
+	if fw.writers.Add(-1) == 0 && err == nil {
+		err = fw.w.Flush()
+	}
+	return err
+}
+
+// readFrame reads the next frame from r.
+func readFrame(r *bufio.Reader) (id uint64, kind byte, payload []byte, err error) {
+	var hdr [tcpFrameHeader]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[0:4])
+	if size < 8+1 || size > maxTCPFrame {
+		return 0, 0, nil, fmt.Errorf("gocache: bad frame size %d", size)
+	}
+	payload = make([]byte, size-8-1)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return binary.BigEndian.Uint64(hdr[4:12]), hdr[12], payload, nil
+}
+
+// serveTCPConn answers the requests of a peer, each in a goroutine of its
+// own.
+func serveTCPConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	fw := &frameWriter{w: bufio.NewWriter(conn)}
+	for {
+		id, kind, payload, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Println("[GoCache] tcp peer", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		go func() {
+			// a panic answers the request with an error rather than
+			// crashing the node
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[GoCache] panic serving tcp peer %v: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
+					if fw.write(id, tcpError, []byte(fmt.Sprint("gocache: panic: ", r))) != nil {
+						conn.Close()
+					}
+				}
+			}()
+			kind, payload := handleTCPRequest(kind, payload)
+			if fw.write(id, kind, payload) != nil {
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// handleTCPRequest answers a request frame with a response frame.
+func handleTCPRequest(kind byte, payload []byte) (byte, []byte) {
+	var (
+		res proto.Message
+		err error
+	)
+	switch kind {
+	case tcpGet:
+		req := &pb.Request{}
+		if err = proto.Unmarshal(payload, req); err == nil {
+			res, err = serveGet(req)
+		}
+	case tcpScan:
+		req := &pb.ScanRequest{}
+		if err = proto.Unmarshal(payload, req); err == nil {
+			var limit int
+			if group := GetGroup(req.GetGroup()); group == nil {
+				err = ErrNoSuchGroup
+			} else if limit, err = scanLimit(int(req.GetLimit())); err == nil {
+				keys, next := group.Scan(req.GetPrefix(), req.GetCursor(), limit)
+				res = &pb.ScanResponse{Keys: keys, Next: next}
+			}
+		}
+	default:
+		err = fmt.Errorf("gocache: unknown request kind %d", kind)
+	}
+
+	if err == nil {
+		var body []byte
+		if body, err = proto.Marshal(res); err == nil && len(body) > maxTCPFrame-8-1 {
+			err = errTCPFrameTooLarge
+		}
+		if err == nil {
+			return tcpResponse, body
+		}
+	}
+	if retry, ok := RetryAfter(err); ok && errors.Is(err, ErrOverloaded) {
+		return tcpOverloaded, binary.BigEndian.AppendUint64(nil, uint64(retry.Milliseconds()))
+	}
+	return tcpError, []byte(err.Error())
+}
+
+// tcpGetter is the client of a peer's TCP protocol. All requests to the
+// peer share one connection, which is redialed once it fails.
+type tcpGetter struct {
+	addr    string
+	timeout atomic.Int64 // see TCPPool.SetTimeout
+
+	mu     sync.Mutex
+	conn   *tcpClientConn
+	closed bool
+}
+
+// tcpClientConn is a connection to a peer and the requests waiting on it.
+type tcpClientConn struct {
+	conn     net.Conn
+	fw       frameWriter
+	nextID   atomic.Uint64
+	lastRead atomic.Int64 // unix nanoseconds of the last frame read
+
+	mu      sync.Mutex
+	pending map[uint64]chan tcpReply
+	err     error // set once the connection failed
+}
+
+type tcpReply struct {
+	kind    byte
+	payload []byte
+}
+
+// Get implements PeerGetter interface.
+func (t *tcpGetter) Get(in *pb.Request, out *pb.Response) error {
+	return t.call(tcpGet, in, out)
+}
+
+// Scan implements PeerScanner interface.
+func (t *tcpGetter) Scan(in *pb.ScanRequest, out *pb.ScanResponse) error {
+	return t.call(tcpScan, in, out)
+}
+
+// call sends a request and decodes the response into out. Errors the
+// peer answered are a serverError or an OverloadedError, any other error
+// means the peer could not be reached.
+func (t *tcpGetter) call(kind byte, in, out proto.Message) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	c, err := t.connection()
+	if err != nil {
+		return err
+	}
+	reply, err := c.roundTrip(kind, body, time.Duration(t.timeout.Load()))
+	if err != nil {
+		return err
+	}
+
+	switch reply.kind {
+	case tcpResponse:
+		if err := proto.Unmarshal(reply.payload, out); err != nil {
+			return fmt.Errorf("decoding response: %v", err)
+		}
+		return nil
+	case tcpOverloaded:
+		retry := defaultRetryAfter
+		if len(reply.payload) == 8 {
+			retry = time.Duration(binary.BigEndian.Uint64(reply.payload)) * time.Millisecond
+		}
+		return &OverloadedError{RetryAfter: retry}
+	case tcpError:
+		return &serverError{status: string(reply.payload)}
+	}
+	return fmt.Errorf("gocache: unknown response kind %d", reply.kind)
+}
+
+// connection returns the connection to the peer, dialing it if there is
+// none or the last one failed.
+func (t *tcpGetter) connection() (*tcpClientConn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, ErrServerClosed
+	}
+	if t.conn != nil && t.conn.failed() == nil {
+		return t.conn, nil
+	}
+
+	dialer := net.Dialer{Timeout: tcpDialTimeout, KeepAlive: tcpKeepAlive}
+	conn, err := dialer.Dial("tcp", t.addr)
+	if err != nil {
+		return nil, err
+	}
+	t.conn = &tcpClientConn{
+		conn:    conn,
+		fw:      frameWriter{w: bufio.NewWriter(conn)},
+		pending: make(map[uint64]chan tcpReply),
+	}
+	go t.conn.readLoop()
+	return t.conn, nil
+}
+
+// close closes the connection to the peer for good.
+func (t *tcpGetter) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.conn != nil {
+		t.conn.conn.Close()
+	}
+}
+
+func (c *tcpClientConn) failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// roundTrip sends a request and waits for its response, up to timeout if
+// positive.
+func (c *tcpClientConn) roundTrip(kind byte, body []byte, timeout time.Duration) (tcpReply, error) {
+	sent := time.Now()
+	id := c.nextID.Add(1)
+	ch := make(chan tcpReply, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return tcpReply{}, c.err
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	if err := c.fw.write(id, kind, body); err != nil {
+		c.fail(err)
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case reply, ok := <-ch:
+		if !ok {
+			return tcpReply{}, c.failed()
+		}
+		return reply, nil
+	case <-expired:
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		// a peer that answered nothing at all since is stalled, rather
+		// than slow to load this key
+		if c.lastRead.Load() < sent.UnixNano() {
+			c.fail(errTCPTimeout)
+		}
+		return tcpReply{}, errTCPTimeout
+	}
+}
+
+// readLoop hands each response to the request waiting for it.
+func (c *tcpClientConn) readLoop() {
+	r := bufio.NewReader(c.conn)
+	for {
+		id, kind, payload, err := readFrame(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.lastRead.Store(time.Now().UnixNano())
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			ch <- tcpReply{kind: kind, payload: payload}
+		}
+	}
+}
+
+// fail closes the connection and fails the requests waiting on it.
+func (c *tcpClientConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = fmt.Errorf("gocache: connection to peer lost: %w", err)
+	c.conn.Close()
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// check that tcpGetter implements PeerGetter and PeerScanner
+var (
+	_ PeerGetter  = (*tcpGetter)(nil)
+	_ PeerScanner = (*tcpGetter)(nil)
+)
@@ -0,0 +1,220 @@
+package gocache
+
+import (
+	"errors"
+	pb "gocache/cachepb"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// startTCPPool serves the TCP peer protocol on loopback.
+func startTCPPool(t testing.TB) *TCPPool {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewTCPPool(l.Addr().String())
+	go p.Serve(l)
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestTCPPool(t *testing.T) {
+	b := newBlockingGetter()
+	g := NewGroup("tcp", 0, b)
+	defer g.Close()
+	g.Set("k1", []byte("630"), Meta{Version: 3})
+
+	server := startTCPPool(t)
+	peer := &tcpGetter{addr: server.Self()}
+	defer peer.close()
+
+	// a slow load does not hold up the requests behind it
+	slow := make(chan error, 1)
+	go func() {
+		res := &pb.Response{}
+		err := peer.Get(&pb.Request{Group: "tcp", Key: "slow"}, res)
+		if err == nil && string(res.GetValue()) != "slow value" {
+			err = errors.New("unexpected value " + string(res.GetValue()))
+		}
+		slow <- err
+	}()
+	v := <-b.entered
+
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "tcp", Key: "k1"}, res); err != nil || string(res.GetValue()) != "630" || res.GetVersion() != 3 {
+		t.Fatalf("expected the cached value, got %v, %v", res, err)
+	}
+	v <- "slow value"
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+
+	scan := &pb.ScanResponse{}
+	if err := peer.Scan(&pb.ScanRequest{Group: "tcp", Limit: 10}, scan); err != nil || len(scan.GetKeys()) != 2 {
+		t.Fatalf("expected both keys to be scanned, got %v, %v", scan.GetKeys(), err)
+	}
+	if err := peer.Scan(&pb.ScanRequest{Group: "tcp"}, &pb.ScanResponse{}); !errors.As(err, new(*serverError)) {
+		t.Fatalf("expected a scan without a limit to be refused, got %v", err)
+	}
+
+	// answered errors are told apart from unreachable peers
+	err := peer.Get(&pb.Request{Group: "tcp-unknown", Key: "k1"}, &pb.Response{})
+	var se *serverError
+	if !errors.As(err, &se) || se.status != ErrNoSuchGroup.Error() {
+		t.Fatalf("expected a serverError, got %v", err)
+	}
+	g.SetLoadLimits(LoadLimits{Rate: 1, Burst: 1})
+	v2, r2 := startGet(g, b, "k2")
+	v2 <- "2"
+	<-r2
+	err = peer.Get(&pb.Request{Group: "tcp", Key: "k3"}, &pb.Response{})
+	if retry, ok := RetryAfter(err); !errors.Is(err, ErrOverloaded) || !ok || retry <= 0 {
+		t.Fatalf("expected an OverloadedError, got %v", err)
+	}
+
+	server.Close()
+	err = peer.Get(&pb.Request{Group: "tcp", Key: "k1"}, &pb.Response{})
+	if err == nil || peerAnswered(err) {
+		t.Fatalf("expected the closed server to be unreachable, got %v", err)
+	}
+}
+
+// TestTCPPoolPipelining tests that concurrent requests share a connection
+// and each gets its own answer.
+func TestTCPPoolPipelining(t *testing.T) {
+	g := NewGroup("tcp-pipelining", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	defer g.Close()
+
+	server := startTCPPool(t)
+	peer := &tcpGetter{addr: server.Self()}
+	defer peer.close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			res := &pb.Response{}
+			if err := peer.Get(&pb.Request{Group: "tcp-pipelining", Key: key}, res); err != nil || string(res.GetValue()) != key {
+				t.Errorf("expected %s, got %q, %v", key, res.GetValue(), err)
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+
+	server.srv.mu.Lock()
+	conns := len(server.srv.conns)
+	server.srv.mu.Unlock()
+	if conns != 1 {
+		t.Fatalf("expected a single connection, got %d", conns)
+	}
+}
+
+// TestTCPPoolPick tests that peers are picked on the same ring as an
+// HTTPPool's, and that removed peers are disconnected.
+func TestTCPPoolPick(t *testing.T) {
+	nodes := []string{"10.0.0.1:9001", "10.0.0.2:9001", "10.0.0.3:9001"}
+	p := NewTCPPool(nodes[0])
+	p.Set(nodes...)
+	ring := NewHTTPPool(nodes[0])
+	ring.Set(nodes...)
+
+	for i := 0; i < 100; i++ {
+		key := "k" + strconv.Itoa(i)
+		owner := ring.Owner(key)
+		peer, ok := p.PickPeer(key)
+		if ok != (owner != nodes[0]) || (ok && peer.(*tcpGetter).addr != owner) {
+			t.Fatalf("expected %s to be owned by %s, picked %v", key, owner, peer)
+		}
+	}
+
+	removed := p.tcpGetters[nodes[2]]
+	p.Set(nodes[:2]...)
+	if _, err := removed.connection(); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("expected the removed peer to be closed, got %v", err)
+	}
+}
+
+// benchmarkPeerGet gets a cached value from peer in parallel.
+func benchmarkPeerGet(b *testing.B, name string, peer PeerGetter) {
+	g := NewGroup(name, 0, GetterFunc(func(key string) ([]byte, error) {
+		return make([]byte, 1024), nil
+	}))
+	b.Cleanup(func() { g.Close() })
+	g.Get("k1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			if err := peer.Get(&pb.Request{Group: name, Key: "k1"}, &pb.Response{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkPeerGetHTTP(b *testing.B) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	NewHTTPPool("").LoadRouters(r)
+	srv := httptest.NewServer(r)
+	b.Cleanup(srv.Close)
+	benchmarkPeerGet(b, "bench-http", &httpGetter{baseURL: srv.URL + defaultBasePath})
+}
+
+func BenchmarkPeerGetTCP(b *testing.B) {
+	server := startTCPPool(b)
+	peer := &tcpGetter{addr: server.Self()}
+	b.Cleanup(peer.close)
+	benchmarkPeerGet(b, "bench-tcp", peer)
+}
+
+// TestTCPPoolTimeout tests that requests to a peer that stopped answering
+// time out, and that its connection is redialed.
+func TestTCPPoolTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			// read the requests, never answer them
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	pool := NewTCPPool("127.0.0.1:0")
+	pool.Set(l.Addr().String())
+	pool.SetTimeout(20 * time.Millisecond)
+	defer pool.Close()
+	peer := pool.tcpGetters[l.Addr().String()]
+
+	for i := 0; i < 2; i++ {
+		err := peer.Get(&pb.Request{Group: "tcp", Key: "k1"}, &pb.Response{})
+		if !errors.Is(err, errTCPTimeout) || peerAnswered(err) {
+			t.Fatalf("expected the request to time out, got %v", err)
+		}
+	}
+	if n := accepted.Load(); n != 2 {
+		t.Fatalf("expected the stalled connection to be redialed, got %d connections", n)
+	}
+}
@@ -0,0 +1,150 @@
+package gocache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// defaultGroupSeparator separates the group from the key in the keys of
+// the protocol frontends, as in "scores:Tom".
+const defaultGroupSeparator = ":"
+
+// ErrServerClosed is returned by the Serve methods of the protocol
+// frontends after Close.
+var ErrServerClosed = errors.New("gocache: server closed")
+
+// tcpServer tracks the listeners and connections of a protocol frontend,
+// so that Close can stop it.
+type tcpServer struct {
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+}
+
+// serve accepts connections on l and hands each to handle in a goroutine
+// of its own, until l fails or the server is closed.
+func (s *tcpServer) serve(l net.Listener, handle func(net.Conn)) error {
+	if !s.track(l, nil) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nil, conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(nil, conn)
+			defer conn.Close()
+			// a panic drops the connection rather than the node, as
+			// net/http does
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[GoCache] panic serving %v: %v\n%s", conn.RemoteAddr(), r, debug.Stack())
+				}
+			}()
+			handle(conn)
+		}()
+	}
+}
+
+func (s *tcpServer) track(l net.Listener, conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if l != nil {
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[l] = struct{}{}
+	}
+	if conn != nil {
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]struct{})
+		}
+		s.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (s *tcpServer) untrack(l net.Listener, conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, l)
+	delete(s.conns, conn)
+}
+
+func (s *tcpServer) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// close closes every listener and connection.
+func (s *tcpServer) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
+
+// readBlock reads a data block of size bytes and the CRLF ending it. The
+// block is read in chunks, so memory grows with the bytes that arrive
+// rather than with the size the client declared.
+func readBlock(r io.Reader, size int) (data []byte, terminated bool, err error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(size)+2); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, false, err
+	}
+	data = buf.Bytes()
+	return data[:size], data[size] == '\r' && data[size+1] == '\n', nil
+}
+
+// splitGroupKey finds the group a frontend key like "scores:Tom" names
+// before sep, and the key within it. Keys naming no existing group belong
+// to defaultGroup as a whole.
+func splitGroupKey(key, sep, defaultGroup string) (*Group, string, error) {
+	if sep == "" {
+		sep = defaultGroupSeparator
+	}
+	if name, k, ok := strings.Cut(key, sep); ok {
+		if g := GetGroup(name); g != nil {
+			return g, k, nil
+		}
+	}
+	if g := GetGroup(defaultGroup); g != nil {
+		return g, key, nil
+	}
+	return nil, "", ErrNoSuchGroup
+}
@@ -0,0 +1,35 @@
+package gocache
+
+import (
+	"net"
+	"testing"
+)
+
+// TestTCPServerPanic tests that a panic serving a connection closes it and
+// leaves the server serving others.
+func TestTCPServerPanic(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &tcpServer{}
+	defer s.close()
+	served := make(chan struct{}, 2)
+	go s.serve(l, func(conn net.Conn) {
+		served <- struct{}{}
+		panic("handler failed")
+	})
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-served
+		// the connection is closed once the handler panicked
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Fatal("expected the connection to be closed")
+		}
+		conn.Close()
+	}
+}
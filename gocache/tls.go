@@ -0,0 +1,136 @@
+package gocache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ErrUnknownPeer is answered to a peer request whose client certificate
+// is not valid for any peer, see HTTPPool.SetRequirePeerCert.
+var ErrUnknownPeer = errors.New("gocache: client certificate names no peer")
+
+// LoadTLSConfig loads the TLS configuration of a node from PEM files: its
+// certificate and key, and the CA that signs the certificates of the
+// cluster. The configuration serves both ends of a connection. With a CA,
+// servers require client certificates signed by it, which makes TLS
+// mutual, and clients check servers against it rather than the system's
+// roots. Certificates should be valid for both server and client auth.
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("gocache: no certificates in %s", caFile)
+	}
+	cfg.RootCAs = cas
+	cfg.ClientCAs = cas
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
+
+// SetTLSConfig makes the pool dial https:// peers with cfg, which holds
+// the CA their certificates are checked against and, for mutual TLS, the
+// certificate this node presents to them. Peers are reconnected with the
+// new configuration.
+func (p *HTTPPool) SetTLSConfig(cfg *tls.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tlsConfig = cfg
+	for peer, getter := range p.httpGetters {
+		if getter.client != nil {
+			getter.client.CloseIdleConnections()
+		}
+		p.httpGetters[peer] = newHTTPGetter(peer, p.basePath, cfg, p.auth)
+	}
+}
+
+// SetRequirePeerCert makes the pool serve gets, scans and membership
+// updates only to clients that present a verified certificate naming one
+// of its peers, see PeerIdentity. Other clients of the same CA, such as
+// applications, are refused with ErrUnknownPeer. Membership updates are
+// also accepted from the managers, see SetManagers, but never on the
+// strength of the list they push. Health checks are not affected.
+func (p *HTTPPool) SetRequirePeerCert(require bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requireCert = require
+}
+
+func (p *HTTPPool) requirePeerCert() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requireCert
+}
+
+// SetManagers allows clients whose verified certificate has one of ids as
+// a URI SAN, e.g. "gocache://manager", to push membership updates when
+// peer certificates are required, besides the peers. A node that only
+// knows itself yet can only be told about the others by a manager.
+func (p *HTTPPool) SetManagers(ids ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.managers = append([]string(nil), ids...)
+}
+
+// mayUpdatePeers reports whether r comes from a peer or a manager.
+func (p *HTTPPool) mayUpdatePeers(r *http.Request) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := namedPeer(r, p.peerList); ok {
+		return true
+	}
+	_, ok := namedPeer(r, p.managers)
+	return ok
+}
+
+// PeerIdentity returns the peer that sent r, identified by the verified
+// client certificate r came with over mutual TLS: the peer whose address
+// is a URI SAN of the certificate, e.g. "https://node2.example:8001". The
+// host alone would not do, as it names every node on the host. ok is
+// false if r came without such a certificate.
+func (p *HTTPPool) PeerIdentity(r *http.Request) (peer string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return namedPeer(r, p.peerList)
+}
+
+// namedPeer returns the first of peers, or other identities, a URI SAN of
+// the verified client certificate of r names.
+func namedPeer(r *http.Request, peers []string) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, peer := range peers {
+		u, err := url.Parse(peer)
+		if err != nil {
+			continue
+		}
+		for _, san := range cert.URIs {
+			if san.Scheme == u.Scheme && strings.EqualFold(san.Host, u.Host) && san.Path == u.Path {
+				return peer, true
+			}
+		}
+	}
+	return "", false
+}
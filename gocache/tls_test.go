@@ -0,0 +1,260 @@
+package gocache
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	pb "gocache/cachepb"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA signs certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gocache test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, der: der}
+}
+
+// issue writes a certificate valid for hosts, signed by ca, and its key
+// to dir, and returns the files they are in. Hosts with a scheme become
+// URI SANs.
+func (ca *testCA) issue(t *testing.T, dir, name string, hosts ...string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, h := range hosts {
+		if u, err := url.Parse(h); err == nil && u.Scheme != "" {
+			tmpl.URIs = append(tmpl.URIs, u)
+		} else if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMutualTLS tests that peers reach each other over mutual TLS, and
+// that only peers get through when peer certificates are required.
+func TestMutualTLS(t *testing.T) {
+	g := NewGroup("tls", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	defer g.Close()
+
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	writePEM(t, caFile, "CERTIFICATE", ca.der)
+	load := func(ca *testCA, name string, hosts ...string) *tls.Config {
+		certFile, keyFile := ca.issue(t, dir, name, hosts...)
+		cfg, err := LoadTLSConfig(certFile, keyFile, caFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	pool := NewHTTPPool("")
+	srv := httptest.NewUnstartedServer(pool)
+	self := "https://" + srv.Listener.Addr().String()
+	node := load(ca, "node", "localhost", "127.0.0.1", self)
+	// signed by the CA, but for a host that is no peer
+	intruder := load(ca, "intruder", "intruder.example")
+	// signed by the CA for the peer's host, but another port
+	neighbour := load(ca, "neighbour", "127.0.0.1", "https://127.0.0.1:1")
+	// signed by the CA for a manager
+	manager := load(ca, "manager", "gocache://manager")
+	// trusts the CA, but is signed by another
+	stranger := load(newTestCA(t), "stranger", "127.0.0.1", self)
+	srv.TLS = node
+	srv.StartTLS()
+	defer srv.Close()
+	pool.Set(srv.URL)
+
+	get := func(cfg *tls.Config) error {
+		peer := newHTTPGetter(srv.URL, defaultBasePath, cfg, nil)
+		res := &pb.Response{}
+		if err := peer.Get(&pb.Request{Group: "tls", Key: "k1"}, res); err != nil {
+			return err
+		}
+		if string(res.GetValue()) != "value of k1" {
+			t.Fatalf("unexpected value %q", res.GetValue())
+		}
+		return nil
+	}
+	if err := get(node); err != nil {
+		t.Fatalf("expected the peer get to succeed, got %v", err)
+	}
+	if err := get(intruder); err != nil {
+		t.Fatalf("expected any certificate of the CA to be accepted, got %v", err)
+	}
+	if err := get(stranger); err == nil || peerAnswered(err) {
+		t.Fatalf("expected the handshake to fail, got %v", err)
+	}
+	if err := get(nil); err == nil || peerAnswered(err) {
+		t.Fatalf("expected the handshake to fail without a certificate, got %v", err)
+	}
+
+	pool.SetRequirePeerCert(true)
+	if err := get(node); err != nil {
+		t.Fatalf("expected the peer get to succeed, got %v", err)
+	}
+	for _, cfg := range []*tls.Config{intruder, neighbour} {
+		var se *serverError
+		if err := get(cfg); !errors.As(err, &se) || se.status != "403 Forbidden" {
+			t.Fatalf("expected the client to be refused, got %v", err)
+		}
+	}
+
+	// membership updates come from peers and managers only
+	setPeers := func(cfg *tls.Config, peers string) int {
+		client := &http.Client{Transport: NewNodeTransport(cfg)}
+		res, err := client.Post(srv.URL+"/set-peers", "application/json", strings.NewReader(peers))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	for _, cfg := range []*tls.Config{intruder, neighbour, manager} {
+		// naming itself in the list it pushes does not make a client a peer
+		if code := setPeers(cfg, `["https://127.0.0.1:1", "gocache://manager"]`); code != http.StatusForbidden || pool.Peers()[0] != self {
+			t.Fatalf("expected the update to be refused, got %d, %v", code, pool.Peers())
+		}
+	}
+	pool.SetManagers("gocache://manager")
+	if code := setPeers(manager, `["`+self+`", "https://127.0.0.1:1"]`); code != http.StatusOK || len(pool.Peers()) != 2 {
+		t.Fatalf("expected the manager's update to be applied, got %d, %v", code, pool.Peers())
+	}
+	if code := setPeers(node, `["`+self+`"]`); code != http.StatusOK || len(pool.Peers()) != 1 {
+		t.Fatalf("expected the peer's update to be applied, got %d, %v", code, pool.Peers())
+	}
+}
+
+// TestPeerIdentity tests that requests are attributed to the peer their
+// client certificate is valid for.
+func TestPeerIdentity(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	parse := func(certFile, _ string) *x509.Certificate {
+		b, err := os.ReadFile(certFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(b)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	request := func(cert *x509.Certificate) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/_gocache/g/k", nil)
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca.cert}}}
+		return r
+	}
+
+	p := NewHTTPPool("https://node1.example:8001")
+	p.Set("https://node1.example:8001", "https://node2.example:8002", "https://node2.example:8001", "unix:///run/gocache.sock")
+	if _, ok := p.PeerIdentity(httptest.NewRequest(http.MethodGet, "/_gocache/g/k", nil)); ok {
+		t.Fatal("expected a request without TLS to name no peer")
+	}
+	r := request(parse(ca.issue(t, dir, "node2", "node2.example", "https://node2.example:8001")))
+	if peer, ok := p.PeerIdentity(r); !ok || peer != "https://node2.example:8001" {
+		t.Fatalf("expected node2, got %q, %v", peer, ok)
+	}
+	if peer, ok := p.PeerIdentity(request(parse(ca.issue(t, dir, "host", "node2.example")))); ok {
+		t.Fatalf("expected a certificate for the host only to name no peer, got %q", peer)
+	}
+	if peer, ok := p.PeerIdentity(request(parse(ca.issue(t, dir, "socket", "unix:///run/gocache.sock")))); !ok || peer != "unix:///run/gocache.sock" {
+		t.Fatalf("expected the socket's node, got %q, %v", peer, ok)
+	}
+	p.Set("https://node1.example:8001")
+	if peer, ok := p.PeerIdentity(r); ok {
+		t.Fatalf("expected a removed peer to be unknown, got %q", peer)
+	}
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue(t, dir, "node", "localhost")
+
+	cfg, err := LoadTLSConfig(certFile, keyFile, "")
+	if err != nil || len(cfg.Certificates) != 1 || cfg.ClientAuth != tls.NoClientCert {
+		t.Fatalf("expected TLS without client certificates, got %v", err)
+	}
+	empty := filepath.Join(dir, "empty.crt")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTLSConfig(certFile, keyFile, empty); err == nil {
+		t.Fatal("expected a CA file without certificates to fail")
+	}
+}
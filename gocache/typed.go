@@ -0,0 +1,192 @@
+package gocache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"gocache/lru"
+	"reflect"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// A Codec converts values to the bytes stored in a Group and back.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes data into v, which is a pointer to the value.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes values as JSON.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes values with encoding/gob.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ProtoCodec encodes protobuf messages in the binary wire format.
+// Values are expected to be message pointers such as *pb.Response.
+type ProtoCodec struct{}
+
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("gocache: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+	// v is a pointer to a message pointer, allocate the message
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Pointer {
+		return fmt.Errorf("gocache: cannot decode proto into %T", v)
+	}
+	msg := reflect.New(rv.Elem().Type().Elem())
+	m, ok := msg.Interface().(proto.Message)
+	if !ok {
+		return fmt.Errorf("gocache: cannot decode proto into %T", v)
+	}
+	if err := proto.Unmarshal(data, m); err != nil {
+		return err
+	}
+	rv.Elem().Set(msg)
+	return nil
+}
+
+// TypedGetter loads a typed value for a key.
+type TypedGetter[T any] interface {
+	Get(key string) (T, error)
+}
+
+// TypedGetterFunc implements TypedGetter with a function.
+type TypedGetterFunc[T any] func(key string) (T, error)
+
+// Get implements TypedGetter interface.
+func (f TypedGetterFunc[T]) Get(key string) (T, error) {
+	return f(key)
+}
+
+// A TypedGroup is a Group whose values are of type T. Values are stored
+// and sent to peers as bytes encoded by a Codec, while the decoded objects
+// are kept locally so that cache hits are not decoded again.
+//
+// Values returned by Get may be shared with other callers and must be
+// treated as read-only.
+type TypedGroup[T any] struct {
+	group *Group
+	codec Codec
+
+	mu      sync.Mutex // guards decoded
+	decoded *lru.Typed[string, decodedValue[T]]
+}
+
+// decodedValue is a decoded object together with the bytes it came from.
+type decodedValue[T any] struct {
+	view  ByteView
+	value T
+}
+
+// sizeOfDecoded counts the encoded size, a cheap estimate of the object's
+// size.
+func sizeOfDecoded[T any](key string, d decodedValue[T]) int64 {
+	return int64(len(key) + d.view.Len())
+}
+
+// NewTypedGroup creates a Group named name whose values are produced by
+// getter and encoded with codec. cacheBytes bounds both the encoded
+// values and, by their encoded size, the decoded objects.
+func NewTypedGroup[T any](name string, cacheBytes int64, codec Codec, getter TypedGetter[T]) *TypedGroup[T] {
+	if getter == nil {
+		panic("nil TypedGetter")
+	}
+
+	g := NewGroup(name, cacheBytes, GetterFunc(func(key string) ([]byte, error) {
+		v, err := getter.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(v)
+	}))
+	return &TypedGroup[T]{
+		group:   g,
+		codec:   codec,
+		decoded: lru.NewTyped[string, decodedValue[T]](cacheBytes, sizeOfDecoded[T], nil),
+	}
+}
+
+// Group returns the underlying Group, e.g. to register peers.
+func (t *TypedGroup[T]) Group() *Group {
+	return t.group
+}
+
+// Close closes the underlying Group, see Group.Close, and releases the
+// decoded objects.
+func (t *TypedGroup[T]) Close() error {
+	err := t.group.Close()
+	t.mu.Lock()
+	t.decoded.Purge()
+	t.mu.Unlock()
+	return err
+}
+
+// Get looks up a key's value and decodes it.
+func (t *TypedGroup[T]) Get(key string) (T, error) {
+	var value T
+	// compare stored views, decompressing only when decoding is needed
+	view, err := t.group.get(key)
+	if err != nil {
+		return value, err
+	}
+
+	t.mu.Lock()
+	if d, ok := t.decoded.Get(key); ok {
+		// the decoded object is only valid for the bytes it came from
+		if d.view.sameData(view) {
+			t.mu.Unlock()
+			return d.value, nil
+		}
+	}
+	t.mu.Unlock()
+
+	raw, err := view.decompress()
+	if err != nil {
+		return value, err
+	}
+	if err := t.codec.Unmarshal(raw.bytes(), &value); err != nil {
+		return value, fmt.Errorf("decoding %s/%s: %v", t.group.name, key, err)
+	}
+
+	t.mu.Lock()
+	// Close purges under mu once the group is closed, so a decode that
+	// raced with it is not kept
+	if !t.group.closed.Load() {
+		t.decoded.Add(key, decodedValue[T]{view: view, value: value})
+	}
+	t.mu.Unlock()
+	return value, nil
+}
@@ -0,0 +1,92 @@
+package gocache
+
+import (
+	"fmt"
+	"testing"
+
+	pb "gocache/cachepb"
+)
+
+type score struct {
+	Name  string
+	Score int
+}
+
+// countingCodec counts Unmarshal calls of the wrapped Codec.
+type countingCodec struct {
+	Codec
+	decodes int
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v interface{}) error {
+	c.decodes++
+	return c.Codec.Unmarshal(data, v)
+}
+
+// TestTypedGroup tests that typed values round-trip through each codec.
+func TestTypedGroup(t *testing.T) {
+	getter := TypedGetterFunc[score](func(key string) (score, error) {
+		var v int
+		if _, err := fmt.Sscan(db[key], &v); err != nil {
+			return score{}, fmt.Errorf("%s not exist", key)
+		}
+		return score{Name: key, Score: v}, nil
+	})
+
+	for name, codec := range map[string]Codec{"json": JSONCodec{}, "gob": GobCodec{}} {
+		g := NewTypedGroup[score]("typed-"+name, 2<<10, codec, getter)
+		defer g.Close()
+		if v, err := g.Get("Tom"); err != nil || v != (score{"Tom", 630}) {
+			t.Fatalf("%s: got %+v, %v", name, v, err)
+		}
+		if _, err := g.Get("unknown"); err == nil {
+			t.Fatalf("%s: the value of unknown should be empty", name)
+		}
+	}
+
+	g := NewTypedGroup[*pb.Response]("typed-proto", 2<<10, ProtoCodec{}, TypedGetterFunc[*pb.Response](
+		func(key string) (*pb.Response, error) {
+			return &pb.Response{Value: []byte(db[key]), Version: 1}, nil
+		}))
+	defer g.Close()
+	if v, err := g.Get("Jack"); err != nil || string(v.GetValue()) != "589" || v.GetVersion() != 1 {
+		t.Fatalf("proto: got %v, %v", v, err)
+	}
+}
+
+// TestTypedGroupDecodeOnce tests that cache hits reuse the decoded object.
+func TestTypedGroupDecodeOnce(t *testing.T) {
+	codec := &countingCodec{Codec: JSONCodec{}}
+	g := NewTypedGroup[score]("typed-once", 2<<10, codec, TypedGetterFunc[score](
+		func(key string) (score, error) {
+			return score{Name: key}, nil
+		}))
+	defer g.Close()
+
+	for i := 0; i < 3; i++ {
+		if v, err := g.Get("Sam"); err != nil || v.Name != "Sam" {
+			t.Fatalf("got %+v, %v", v, err)
+		}
+	}
+	if codec.decodes != 1 {
+		t.Fatalf("expected 1 decode, got %d", codec.decodes)
+	}
+}
+
+func TestTypedGroupClose(t *testing.T) {
+	g := NewTypedGroup[score]("typed-close", 2<<10, JSONCodec{}, TypedGetterFunc[score](
+		func(key string) (score, error) {
+			return score{Name: key}, nil
+		}))
+	g.Get("Sam")
+
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Get("Sam"); err != ErrGroupClosed {
+		t.Fatalf("expected ErrGroupClosed, got %v", err)
+	}
+	if n := g.decoded.Len(); n != 0 {
+		t.Fatalf("closed group still holds %d decoded objects", n)
+	}
+}
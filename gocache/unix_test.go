@@ -0,0 +1,84 @@
+package gocache
+
+import (
+	pb "gocache/cachepb"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestUnixSocket tests that peers can be reached over a Unix domain
+// socket.
+func TestUnixSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g := NewGroup("unix", 0, GetterFunc(func(key string) ([]byte, error) {
+		return []byte("value of " + key), nil
+	}))
+	defer g.Close()
+
+	addr := unixScheme + filepath.Join(t.TempDir(), "node.sock")
+	l, err := Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	NewHTTPPool(addr).LoadRouters(r)
+	srv := &http.Server{Handler: r}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	p := NewHTTPPool("unix:///elsewhere.sock")
+	p.Set(p.Self(), addr)
+	var peer PeerGetter
+	for _, key := range []string{"k1", "k2", "k3", "k4", "k5", "k6"} {
+		if got, ok := p.PickPeer(key); ok {
+			peer = got
+			break
+		}
+	}
+	if peer == nil {
+		t.Fatalf("expected the socket's node to own a key")
+	}
+	res := &pb.Response{}
+	if err := peer.Get(&pb.Request{Group: "unix", Key: "k1"}, res); err != nil || string(res.GetValue()) != "value of k1" {
+		t.Fatalf("expected the value over the socket, got %q, %v", res.GetValue(), err)
+	}
+
+	// clients reach the socket with a node transport, e.g. for the health check
+	client := &http.Client{Transport: NewNodeTransport(nil)}
+	hres, err := client.Get(NodeURL(addr) + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hres.Body.Close()
+	if hres.StatusCode != http.StatusOK {
+		t.Fatalf("expected the health check to succeed, got %d", hres.StatusCode)
+	}
+	if u := NodeURL("http://localhost:8001"); u != "http://localhost:8001" {
+		t.Fatalf("expected tcp addresses to be kept, got %q", u)
+	}
+
+	if _, err := Listen(addr); err == nil {
+		t.Fatalf("expected a socket in use not to be replaced")
+	}
+}
+
+// TestListenStaleSocket tests that Listen replaces a socket file nobody
+// listens on any more.
+func TestListenStaleSocket(t *testing.T) {
+	addr := unixScheme + filepath.Join(t.TempDir(), "stale.sock")
+	l, err := Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	if l, err = Listen(addr); err != nil {
+		t.Fatalf("expected the stale socket to be replaced, got %v", err)
+	}
+	l.Close()
+}
@@ -1,18 +1,74 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"gocache"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// nodeAddrs are the cache nodes the manager and the proxy know of.
+var nodeAddrs = []string{
+	"http://localhost:8001",
+	"http://localhost:8002",
+	"http://localhost:8003",
+}
+
+// apiAddr is where the api server, or the proxy, listens by default.
+const apiAddr = "http://localhost:9999"
+
+const groupName = "scores"
+
+var (
+	// tlsConfig serves and dials every http server over TLS, mutual if it
+	// has a CA, see -cert, -key and -ca. nil serves plain http.
+	tlsConfig *tls.Config
+	// requirePeerCert serves peer gets and membership updates only to
+	// certificates naming a node, see -peercert.
+	requirePeerCert bool
+	// managerID is the URI SAN of the manager's certificate, which may
+	// update the membership under -peercert, see -mgrid.
+	managerID string
+	// httpClient is what the manager checks and updates nodes with, also
+	// those on unix:// sockets.
+	httpClient = &http.Client{Transport: gocache.NewNodeTransport(nil)}
+
+	// peerAuth signs and checks the requests between nodes, the proxy and
+	// the manager, see -authfile. nil lets anyone in.
+	peerAuth gocache.Authenticator
+	// apiAuth and apiRules guard the api and admin servers.
+	apiAuth  gocache.Authenticator
+	apiRules gocache.Authorizer
+)
+
+// clusterKeyID names the shared secret of the cluster, and is the principal
+// of requests signed with it.
+const clusterKeyID = "cluster"
+
+// authConfig is the content of -authfile, e.g.
+//
+//	{
+//	  "secret": "shared by the nodes, the proxy, the manager and gocachectl",
+//	  "tokens": {"t0ken": "alice"},
+//	  "rules": [{"principal": "alice", "group": "scores", "access": "rw"}]
+//	}
+type authConfig struct {
+	Secret string            `json:"secret"`
+	Tokens map[string]string `json:"tokens"` // principals by bearer token
+	Rules  gocache.Rules     `json:"rules"`
+}
+
 var db = map[string]string{
 	"Tom":  "630",
 	"Jack": "589",
@@ -20,7 +76,7 @@ var db = map[string]string{
 }
 
 func createGroup() *gocache.Group {
-	return gocache.NewGroup("scores", 2<<10, gocache.GetterFunc(
+	return gocache.NewGroup(groupName, 2<<10, gocache.GetterFunc(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			time.Sleep(150 * time.Millisecond) // simulate slow database
@@ -31,35 +87,187 @@ func createGroup() *gocache.Group {
 		}))
 }
 
-func startCacheServer(addr string, g *gocache.Group) {
+func startCacheServer(addr string, adminAddr string, g *gocache.Group, tcpPeers bool) {
 	peers := gocache.NewHTTPPool(addr)
+	peers.SetTLSConfig(tlsConfig)
+	peers.SetRequirePeerCert(requirePeerCert)
+	peers.SetManagers(managerID)
+	peers.SetAuthenticator(peerAuth)
 	// set peers
 	peers.Set(addr)
-	g.RegisterPeers(peers)
+	if tcpPeers {
+		startTCPPeers(addr, peers, g)
+	} else {
+		g.RegisterPeers(peers)
+	}
 
 	// start http server
 	r := gin.Default()
 	peers.LoadRouters(r)
+	admin := gocache.NewAdmin(peers)
+	admin.SetAuthenticator(apiAuth)
+	admin.SetAuthorizer(apiRules)
+	if adminAddr == "" {
+		admin.LoadRouters(r)
+	} else {
+		go startAdminServer(adminAddr, admin)
+	}
 	log.Println("gocache is running at", addr)
-	addr = strings.TrimPrefix(addr, "http://")
-	r.Run(addr)
+	run(r, addr)
+}
+
+// run serves r on addr, a tcp address or a unix:// socket, over TLS if
+// it is configured.
+func run(r *gin.Engine, addr string) {
+	l, err := gocache.Listen(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	log.Fatal(r.RunListener(l))
+}
+
+// startTCPPeers makes the nodes talk to each other over the binary tcp
+// protocol, each on its http port + 1000. Membership still arrives over
+// http at /set-peers.
+func startTCPPeers(addr string, peers *gocache.HTTPPool, g *gocache.Group) {
+	self, err := tcpAddr(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tcpPool := gocache.NewTCPPool(self)
+	tcpPool.Set(self)
+	peers.OnSet(func(addrs []string) {
+		tcpAddrs := make([]string, 0, len(addrs))
+		for _, a := range addrs {
+			// a node that cannot speak tcp is left out, not fatal
+			t, err := tcpAddr(a)
+			if err != nil {
+				log.Println("Skip tcp peer:", err)
+				continue
+			}
+			tcpAddrs = append(tcpAddrs, t)
+		}
+		tcpPool.Set(tcpAddrs...)
+	})
+	g.RegisterPeers(tcpPool)
+
+	log.Println("tcp peer server is running at", tcpPool.Self())
+	go func() {
+		log.Fatal(tcpPool.ListenAndServe())
+	}()
+}
+
+// tcpAddr maps the http address of a node to the address of its tcp peer
+// server, e.g. http://localhost:8001 to localhost:9001.
+func tcpAddr(httpAddr string) (string, error) {
+	rest, ok := strings.CutPrefix(httpAddr, "http://")
+	if !ok {
+		return "", fmt.Errorf("%s: the tcp peer protocol needs http:// nodes with a port", httpAddr)
+	}
+	host, port, err := net.SplitHostPort(rest)
+	if err != nil {
+		return "", err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p+1000)), nil
+}
+
+func startAdminServer(adminAddr string, admin *gocache.Admin) {
+	r := gin.Default()
+	admin.LoadRouters(r)
+	log.Println("admin server is running at", adminAddr)
+	run(r, adminAddr)
+}
+
+// startRESPServer lets redis clients read the cache, e.g.
+// redis-cli -p 6379 GET Tom
+func startRESPServer(respAddr string) {
+	log.Println("resp server is running at", respAddr)
+	log.Fatal(gocache.NewRESPServer(groupName).ListenAndServe(respAddr))
+}
+
+// startMemcacheServer lets memcached clients read the cache, e.g.
+// printf "get Tom\r\n" | nc localhost 11211
+func startMemcacheServer(memcacheAddr string) {
+	log.Println("memcache server is running at", memcacheAddr)
+	log.Fatal(gocache.NewMemcacheServer(groupName).ListenAndServe(memcacheAddr))
 }
 
 func startAPIServer(apiAddr string, g *gocache.Group) {
+	serveAPI(apiAddr, g.Get, nil)
+}
+
+// startProxyServer serves the api without a cache of its own, forwarding
+// each key straight to the node that owns it.
+func startProxyServer(apiAddr string, addrs []string) {
+	peers := gocache.NewHTTPPool(apiAddr)
+	peers.SetTLSConfig(tlsConfig)
+	peers.SetRequirePeerCert(requirePeerCert)
+	peers.SetManagers(managerID)
+	peers.SetAuthenticator(peerAuth)
+	peers.Set(addrs...)
+	proxy := gocache.NewProxy(peers)
+	serveAPI(apiAddr, func(key string) (gocache.ByteView, error) {
+		return proxy.Get(groupName, key)
+	}, proxy.LoadRouters)
+}
+
+// serveAPI serves GET /api?key= with get, plus any routes load registers.
+func serveAPI(apiAddr string, get func(key string) (gocache.ByteView, error), load func(*gin.Engine)) {
 	r := gin.Default()
-	r.GET("/api", func(c *gin.Context) {
+	if load != nil {
+		load(r)
+	}
+	r.GET("/api", authorizeAPI(groupName, gocache.Read), func(c *gin.Context) {
 		key := c.Query("key")
-		view, err := g.Get(key)
+		view, err := get(key)
+		if retry, ok := gocache.RetryAfter(err); ok {
+			// the key is loading elsewhere or loads are shed, let the
+			// client come back
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			c.String(http.StatusServiceUnavailable, err.Error())
+			return
+		}
 		if err != nil {
 			c.String(http.StatusInternalServerError, err.Error())
 			return
 		}
 
-		c.Data(http.StatusOK, "application/octet-stream", view.ByteSlice())
+		contentType := view.Meta().ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		c.Data(http.StatusOK, contentType, view.ByteSlice())
 	})
 	log.Println("fontend server is running at", apiAddr)
-	apiAddr = strings.TrimPrefix(apiAddr, "http://")
-	r.Run(apiAddr)
+	run(r, apiAddr)
+}
+
+// authorizeAPI answers 401 to api requests without valid credentials and
+// 403 to those whose principal may not access group.
+func authorizeAPI(group string, access gocache.Access) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := ""
+		if apiAuth != nil {
+			var err error
+			if principal, err = apiAuth.Authenticate(c.Request); err != nil {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+		}
+		if apiRules != nil {
+			if err := apiRules.Authorize(principal, group, access); err != nil {
+				c.String(http.StatusForbidden, err.Error())
+				c.Abort()
+			}
+		}
+	}
 }
 
 func isSameSlice(a, b []string) bool {
@@ -74,7 +282,7 @@ func isSameSlice(a, b []string) bool {
 	return true
 }
 
-func startMgrServer(allAddrs []string) {
+func startMgrServer(allAddrs []string, proxyAddrs []string) {
 	log.Println("Start manager server")
 	// 每隔 5s 轮询一次，更新节点信息
 	addrs := []string{}
@@ -86,8 +294,11 @@ func startMgrServer(allAddrs []string) {
 			if !isSameSlice(availableAddrs, addrs) {
 				addrs = availableAddrs
 				log.Println("Update addrs", addrs)
-				updateNodeInfo(addrs)
 			}
+			// push every round, so nodes and proxies that restarted or
+			// missed an update catch up
+			updateNodeInfo(addrs, addrs)
+			updateNodeInfo(getAvailableAddrs(proxyAddrs), addrs)
 			ch <- true
 		}(done)
 		time.Sleep(5 * time.Second)
@@ -95,16 +306,22 @@ func startMgrServer(allAddrs []string) {
 	}
 }
 
-func updateNodeInfo(peers []string) {
-	for _, peer := range peers {
+// updateNodeInfo pushes the list of peers to every target.
+func updateNodeInfo(targets []string, peers []string) {
+	for _, target := range targets {
 		jsonData, err := json.Marshal(peers)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
-		_, err = http.Post(peer+"/set-peers", "application/json", strings.NewReader(string(jsonData)))
+		resp, err := httpClient.Post(gocache.NodeURL(target)+"/set-peers", "application/json", strings.NewReader(string(jsonData)))
 		if err != nil {
 			log.Println("Update node info error: ", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Println("Update node info error: ", target, resp.Status)
 		}
 	}
 }
@@ -122,7 +339,7 @@ func getAvailableAddrs(addrs []string) []string {
 
 func isAddrAvailable(addr string) bool {
 	// 发送 HTTP 请求检查地址是否可访问
-	resp, err := http.Get(addr)
+	resp, err := httpClient.Get(gocache.NodeURL(addr))
 	if err != nil {
 		return false
 	}
@@ -132,32 +349,160 @@ func isAddrAvailable(addr string) bool {
 
 func main() {
 	var (
-		port int
-		api  bool
-		mgr  bool
+		port      int
+		addr      string
+		api       string
+		adminPort int
+		respPort  int
+		mcPort    int
+		tcpPeers  bool
+		withAPI   bool
+		mgr       bool
+		proxy     bool
+		loadWait  time.Duration
+		certFile  string
+		keyFile   string
+		caFile    string
+		authFile  string
 	)
 	// cli arguments
 	flag.IntVar(&port, "port", 8001, "gocache server port") // which port to listen
-	flag.BoolVar(&api, "api", false, "start a api server?")
+	flag.StringVar(&addr, "addr", "", "gocache server address, e.g. unix:///tmp/gocache.sock for co-located clients, overrides -port")
+	flag.StringVar(&api, "apiaddr", apiAddr, "api server address, may be a unix:// socket")
+	flag.IntVar(&adminPort, "admin", 0, "admin api port, 0 serves it on the gocache server port")
+	flag.IntVar(&respPort, "resp", 0, "redis protocol port, 0 disables it")
+	flag.IntVar(&mcPort, "memcache", 0, "memcached protocol port, 0 disables it")
+	flag.BoolVar(&tcpPeers, "tcp", false, "talk to peers over the binary tcp protocol on port+1000 instead of http?")
+	flag.BoolVar(&withAPI, "api", false, "start a api server?")
 	flag.BoolVar(&mgr, "mgr", false, "start a manager server?")
+	flag.BoolVar(&proxy, "proxy", false, "start only a api server that forwards to the cache nodes, holding no cache?")
+	flag.DurationVar(&loadWait, "loadwait", 0, "how long peers wait for a key this node is loading before being told to retry, 0 waits")
+	flag.StringVar(&certFile, "cert", "", "certificate to serve https and to present to peers with, enables TLS")
+	flag.StringVar(&keyFile, "key", "", "private key of -cert")
+	flag.StringVar(&caFile, "ca", "", "CA that signs the certificates of the cluster, enables mutual TLS")
+	flag.BoolVar(&requirePeerCert, "peercert", false, "serve peer gets and /set-peers only to certificates naming a node in a URI SAN, e.g. https://localhost:8001, or the manager, see -mgrid")
+	flag.StringVar(&managerID, "mgrid", "gocache://manager", "URI SAN of the manager's certificate, which may push /set-peers under -peercert")
+	flag.StringVar(&authFile, "authfile", "", "json file with the cluster secret, api tokens and per-group rules, see authConfig")
 	flag.Parse()
 
-	if !mgr {
-		addr := fmt.Sprintf("http://localhost:%d", port)
+	if tcpPeers && addr != "" && !strings.HasPrefix(addr, "http://") {
+		log.Fatal("the tcp peer protocol runs on the port of an http:// -addr + 1000, drop -tcp or use -port")
+	}
+	if authFile != "" {
+		setupAuth(authFile, tcpPeers, respPort != 0 || mcPort != 0)
+	}
+	if requirePeerCert && caFile == "" {
+		log.Fatal("-peercert needs mutual TLS, add -cert, -key and -ca")
+	}
+	if certFile != "" {
+		setupTLS(certFile, keyFile, caFile, tcpPeers)
+		if api == apiAddr {
+			api = httpsAddr(api)
+		}
+	}
+
+	if proxy {
+		startProxyServer(api, nodeAddrs)
+	} else if !mgr {
+		if addr == "" {
+			addr = fmt.Sprintf("%s://localhost:%d", scheme(), port)
+		}
 		g := createGroup()
-		if api {
-			apiAddr := "http://localhost:9999"
-			go startAPIServer(apiAddr, g)
+		g.SetLoadWait(loadWait)
+		if withAPI {
+			go startAPIServer(api, g)
 		}
-		startCacheServer(addr, g)
-	} else {
-		addrs := []string{
-			"http://localhost:8001",
-			"http://localhost:8002",
-			"http://localhost:8003",
+		if respPort != 0 {
+			go startRESPServer(fmt.Sprintf("localhost:%d", respPort))
+		}
+		if mcPort != 0 {
+			go startMemcacheServer(fmt.Sprintf("localhost:%d", mcPort))
+		}
+		adminAddr := ""
+		if adminPort != 0 {
+			adminAddr = fmt.Sprintf("%s://localhost:%d", scheme(), adminPort)
 		}
-		startMgrServer(addrs)
+		startCacheServer(addr, adminAddr, g, tcpPeers)
+	} else {
+		startMgrServer(nodeAddrs, []string{api})
+	}
+}
+
+// setupTLS loads the TLS configuration of the node, which then reaches the
+// other nodes, and is reached, over https.
+func setupTLS(certFile, keyFile, caFile string, tcpPeers bool) {
+	if tcpPeers {
+		log.Fatal("the tcp peer protocol does not support TLS, drop -tcp")
+	}
+	cfg, err := gocache.LoadTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tlsConfig = cfg
+	t := gocache.NewNodeTransport(cfg)
+	httpClient = &http.Client{Transport: t}
+	if peerAuth != nil {
+		httpClient.Transport = gocache.NewAuthTransport(peerAuth, t)
+	}
+	for i, a := range nodeAddrs {
+		nodeAddrs[i] = httpsAddr(a)
+	}
+}
+
+// setupAuth loads the authentication of the cluster from file. Nodes, the
+// proxy and the manager sign their requests to each other with the secret,
+// and the api and admin servers accept it as well as the tokens. Rules
+// apply to the principals of the tokens; the cluster's own principal may
+// do anything. The tcp peer protocol and the redis and memcached
+// frontends cannot authenticate, so they are refused.
+func setupAuth(file string, tcpPeers, frontends bool) {
+	if tcpPeers {
+		log.Fatal("the tcp peer protocol does not support authentication, drop -tcp")
+	}
+	if frontends {
+		// they would let anyone read and write what the rules guard
+		log.Fatal("the redis and memcached protocols do not support authentication, drop -resp and -memcache")
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var cfg authConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	var auths []gocache.Authenticator
+	if cfg.Secret != "" {
+		peerAuth = gocache.NewHMACAuth(clusterKeyID, []byte(cfg.Secret))
+		httpClient = &http.Client{Transport: gocache.NewAuthTransport(peerAuth, httpClient.Transport)}
+		auths = append(auths, peerAuth)
+	}
+	if len(cfg.Tokens) > 0 {
+		auths = append(auths, &gocache.BearerAuth{Tokens: cfg.Tokens})
+	}
+	if len(auths) > 0 {
+		apiAuth = gocache.MultiAuth(auths...)
+	}
+	if cfg.Rules != nil {
+		apiRules = append(cfg.Rules, gocache.Rule{Principal: clusterKeyID, Group: "*", Access: gocache.Read | gocache.Write})
+	}
+}
+
+// scheme is the scheme of the addresses of this node's http servers.
+func scheme() string {
+	if tlsConfig != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// httpsAddr turns an http:// address into an https:// one.
+func httpsAddr(addr string) string {
+	if rest, ok := strings.CutPrefix(addr, "http://"); ok {
+		return "https://" + rest
 	}
+	return addr
 }
 
 func init() {
@@ -4,7 +4,8 @@ trap "rm server;kill 0" EXIT
 go build -o server
 ./server -port=8001 &
 ./server -port=8002 &
-./server -port=8003 -api=1 & # api:9999 和 port:8003 会共用同一个缓存，待解决
+./server -port=8003 &
+./server -proxy=1 & # api:9999 不持有缓存，直接把请求转发给 key 所在的节点
 
 sleep 2
 echo ">>> start test"